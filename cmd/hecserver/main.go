@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent/flagarray"
+	"github.com/djschaap/logevent/fromenv"
+	"github.com/djschaap/logevent/internal/envfile"
+	"github.com/djschaap/logevent/internal/hecserver"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	buildDt string
+	commit  string
+	version string
+)
+
+func printVersion() {
+	fmt.Println("logevent hecserver  Version:",
+		version, " Commit:", commit,
+		" Built at:", buildDt)
+}
+
+func main() {
+	printVersion()
+
+	listenAddr := flag.String("listen", ":8088", "address to listen on")
+	var tokenArgs flagarray.StringArray
+	flag.Var(&tokenArgs, "token", "accepted HEC token, may be repeated (default $HECSERVER_TOKENS, comma-separated)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	maxContentLength := flag.Int64("max-content-length", 1000000, "maximum request body size, in bytes")
+	printVersion := flag.Bool("v", false, "print version and exit")
+	flag.Parse()
+	if *printVersion {
+		os.Exit(0)
+	}
+
+	err := envfile.Load(".env", false)
+	if err != nil {
+		log.Fatal("Error loading .env file:", err)
+	}
+	tokens := []string(tokenArgs)
+	if len(tokens) == 0 {
+		tokens = strings.Split(os.Getenv("HECSERVER_TOKENS"), ",")
+	}
+	if len(tokens) == 0 || tokens[0] == "" {
+		log.Fatal("hecserver requires at least one -token (or HECSERVER_TOKENS)")
+	}
+
+	sender, err := fromenv.GetMessageSenderFromEnv()
+	if err != nil {
+		log.Fatal("Error initializing output:", err)
+	}
+	err = sender.OpenSvc()
+	if err != nil {
+		log.Fatal("Error from OpenSvc:", err)
+	}
+	defer sender.CloseSvc()
+
+	handler := hecserver.New(sender, tokens)
+	handler.SetMaxContentLength(*maxContentLength)
+	trace, _ := fromenv.GetenvBool("SENDER_TRACE")
+	handler.SetTrace(trace)
+	srv := &http.Server{
+		Addr:    *listenAddr,
+		Handler: handler,
+	}
+
+	idle := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Println("received", sig, "; shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(idle)
+	}()
+
+	log.Println("hecserver listening on", *listenAddr)
+	if *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal("Error from ListenAndServe: ", err)
+	}
+	<-idle
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/fromenv"
+	"github.com/djschaap/logevent/internal/envfile"
+	"github.com/djschaap/logevent/internal/relay"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	buildDt string
+	commit  string
+	version string
+)
+
+func printVersion() {
+	fmt.Println("logevent relay  Version:",
+		version, " Commit:", commit,
+		" Built at:", buildDt)
+}
+
+func main() {
+	printVersion()
+
+	amqpURL := flag.String("url", "", "AMQP URL to consume from (default $RELAY_AMQP_URL)")
+	queue := flag.String("queue", "", "AMQP queue to consume from (default $RELAY_QUEUE)")
+	backoff := flag.Duration("backoff", time.Second, "stop consuming this long after a failed batch, doubling per consecutive failure")
+	maxBackoff := flag.Duration("max-backoff", 30*time.Second, "maximum backoff after failed batches")
+	batchSize := flag.Int("batch-size", 100, "forward up to N events per batch")
+	batchTimeout := flag.Duration("batch-timeout", time.Second, "forward a partial batch after this long")
+	concurrency := flag.Int("concurrency", 1, "number of consumer/sender workers")
+	prefetch := flag.Int("prefetch", 100, "AMQP prefetch count, per worker")
+	requeue := flag.Bool("requeue", true, "requeue failed batches; if false, reject them (dead-letter)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "wait this long for in-flight batches at shutdown")
+	printVersion := flag.Bool("v", false, "print version and exit")
+	flag.Parse()
+	if *printVersion {
+		os.Exit(0)
+	}
+
+	err := envfile.Load(".env", false)
+	if err != nil {
+		log.Fatal("Error loading .env file:", err)
+	}
+	if *amqpURL == "" {
+		*amqpURL = os.Getenv("RELAY_AMQP_URL")
+	}
+	if *queue == "" {
+		*queue = os.Getenv("RELAY_QUEUE")
+	}
+	if *amqpURL == "" || *queue == "" {
+		log.Fatal("relay requires -url and -queue (or RELAY_AMQP_URL and RELAY_QUEUE)")
+	}
+
+	// fail fast on bad output configuration, before consuming anything
+	report := fromenv.Validate(false)
+	if report.HasErrors() {
+		fmt.Print(report)
+		log.Fatal("Error initializing output: configuration has errors")
+	}
+
+	r := relay.New(*amqpURL, *queue, func() (logevent.MessageSender, error) {
+		return fromenv.GetMessageSenderFromEnv()
+	})
+	r.SetBackoff(*backoff, *maxBackoff)
+	r.SetBatchSize(*batchSize)
+	r.SetBatchTimeout(*batchTimeout)
+	r.SetConcurrency(*concurrency)
+	r.SetPrefetch(*prefetch)
+	r.SetRequeue(*requeue)
+	r.SetShutdownTimeout(*shutdownTimeout)
+	trace, _ := fromenv.GetenvBool("SENDER_TRACE")
+	r.SetTrace(trace)
+
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Println("received", sig, "; shutting down")
+		close(stop)
+	}()
+
+	err = r.Run(stop)
+	if err != nil {
+		log.Fatal("Error from relay: ", err)
+	}
+}
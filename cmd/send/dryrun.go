@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"io"
+	"sort"
+	"sync"
+)
+
+// dryRunSender prints what a sender would send for each LogEvent instead of sending it.
+type dryRunSender struct {
+	mtx      *sync.Mutex // shared by all dryRunSenders writing to out
+	out      io.Writer
+	renderer logevent.Renderer
+}
+
+func (s *dryRunSender) CloseSvc() error { return nil }
+func (s *dryRunSender) OpenSvc() error  { return nil }
+func (s *dryRunSender) SetTrace(bool)   {}
+
+// SendMessage writes the rendered payload for logEvent.
+func (s *dryRunSender) SendMessage(logEvent logevent.LogEvent) error {
+	payload, err := s.renderer.Render(logEvent)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	writePayload(s.out, payload)
+	return nil
+}
+
+// dryRun wraps newSender so that the senders it returns render events to out instead of sending them.
+func dryRun(newSender func() (logevent.MessageSender, error), out io.Writer) func() (logevent.MessageSender, error) {
+	var mtx sync.Mutex
+	return func() (logevent.MessageSender, error) {
+		sender, err := newSender()
+		if err != nil {
+			return nil, err
+		}
+		renderer, ok := sender.(logevent.Renderer)
+		if !ok {
+			return nil, errors.New("-dry-run is not supported by this sender")
+		}
+		return &dryRunSender{mtx: &mtx, out: out, renderer: renderer}, nil
+	}
+}
+
+// writePayload writes the target, headers, properties and body of payload, followed by a blank line.
+func writePayload(w io.Writer, payload logevent.Payload) {
+	fmt.Fprintf(w, "target: %s\n", payload.Target)
+	writeSorted(w, "header", payload.Headers)
+	writeSorted(w, "property", payload.Properties)
+	fmt.Fprintf(w, "\n%s\n\n", payload.Body)
+}
+
+func writeSorted(w io.Writer, label string, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s: %s: %s\n", label, k, m[k])
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"github.com/djschaap/logevent"
+	"testing"
+)
+
+type fakeRenderer struct {
+	fakeSender
+}
+
+func (r *fakeRenderer) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	payload := logevent.Payload{
+		Body:       []byte(logEvent.Content.Event.(string)),
+		Headers:    map[string]string{"b": "2", "a": "1"},
+		Properties: map[string]string{"p": "v"},
+		Target:     "somewhere",
+	}
+	return payload, nil
+}
+
+func TestDryRun(t *testing.T) {
+	var out bytes.Buffer
+	renderer := &fakeRenderer{}
+	newDryRunSender := dryRun(func() (logevent.MessageSender, error) { return renderer, nil }, &out)
+	sender, err := newDryRunSender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.OpenSvc()
+	err = sender.SendMessage(logevent.LogEvent{Content: logevent.MessageContent{Event: "body"}})
+	if err != nil {
+		t.Errorf("SendMessage() returned unexpected error %v", err)
+	}
+	sender.CloseSvc()
+	if len(renderer.received) != 0 {
+		t.Errorf("expected nothing sent, got %#v", renderer.received)
+	}
+	expect := "target: somewhere\nheader: a: 1\nheader: b: 2\nproperty: p: v\n\nbody\n\n"
+	if out.String() != expect {
+		t.Errorf("expected output %q, got %q", expect, out.String())
+	}
+
+	t.Run("sender without Render",
+		func(t *testing.T) {
+			newDryRunSender := dryRun(func() (logevent.MessageSender, error) { return &fakeSender{}, nil }, &out)
+			_, err := newDryRunSender()
+			if err == nil {
+				t.Error("expected error from sender without Render but got nil")
+			}
+		},
+	)
+}
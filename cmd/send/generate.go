@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// generator sends events rendered from a template at a target rate, using one sender per worker.
+type generator struct {
+	duration  time.Duration
+	evFlags   *eventFlags
+	newSender func() (logevent.MessageSender, error)
+	ndjson    bool    // rendered output is a JSON LogEvent or MessageContent
+	rate      float64 // events per second across all workers; 0 for no limit
+	seq       int64
+	template  *template.Template
+	workers   int
+}
+
+// templateData is passed to the event template.
+type templateData struct {
+	Seq    int64     // sequence number of this event, starting at 1
+	Time   time.Time // time at which this event was rendered
+	Worker int       // worker number, starting at 1
+}
+
+// workerStats records the outcome of one worker's sends.
+type workerStats struct {
+	errors    map[string]int
+	failed    int
+	latencies []time.Duration
+	sent      int
+}
+
+// generateReport summarizes a generator run.
+type generateReport struct {
+	elapsed time.Duration
+	workers []workerStats
+}
+
+// templateFuncs are the helpers available to event templates.
+var templateFuncs = template.FuncMap{
+	"choice": func(choices ...string) string {
+		if len(choices) == 0 {
+			return ""
+		}
+		return choices[rand.Intn(len(choices))]
+	},
+	"epoch": func() int64 {
+		return time.Now().Unix()
+	},
+	"randFloat": func(min, max float64) float64 {
+		return min + rand.Float64()*(max-min)
+	},
+	"randHex": func(n int) string {
+		b := make([]byte, (n+1)/2)
+		rand.Read(b)
+		return fmt.Sprintf("%x", b)[:n]
+	},
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min+1)
+	},
+	"timestamp": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	},
+}
+
+// parseEventTemplate parses an event template with the generator helpers.
+func parseEventTemplate(text string) (*template.Template, error) {
+	return template.New("event").Funcs(templateFuncs).Parse(text)
+}
+
+// run sends events until the duration elapses or stop is closed.
+func (g *generator) run(stop <-chan struct{}) (generateReport, error) {
+	workers := g.workers
+	if workers < 1 {
+		workers = 1
+	}
+	senders := make([]logevent.MessageSender, workers)
+	for i := range senders {
+		sender, err := g.newSender()
+		if err != nil {
+			return generateReport{}, err
+		}
+		err = sender.OpenSvc()
+		if err != nil {
+			return generateReport{}, err
+		}
+		defer sender.CloseSvc()
+		senders[i] = sender
+	}
+
+	jobs := make(chan struct{}, workers)
+	report := generateReport{workers: make([]workerStats, workers)}
+	var wg sync.WaitGroup
+	for i := range senders {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.workers[i] = g.work(i+1, senders[i], jobs)
+		}(i)
+	}
+
+	start := time.Now()
+	var deadline <-chan time.Time
+	if g.duration > 0 {
+		timer := time.NewTimer(g.duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var interval time.Duration
+	if g.rate > 0 {
+		interval = time.Duration(float64(time.Second) / g.rate)
+	}
+dispatch:
+	for n := 0; ; n++ {
+		if interval > 0 {
+			// schedule against the start time so slow sends do not reduce the rate
+			wait := time.Until(start.Add(time.Duration(n) * interval))
+			if wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-stop:
+					t.Stop()
+					break dispatch
+				case <-deadline:
+					t.Stop()
+					break dispatch
+				case <-t.C:
+				}
+			}
+		}
+		select {
+		case <-stop:
+			break dispatch
+		case <-deadline:
+			break dispatch
+		case jobs <- struct{}{}:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	report.elapsed = time.Since(start)
+	return report, nil
+}
+
+func (g *generator) render(worker int) (logevent.LogEvent, error) {
+	var buf bytes.Buffer
+	data := templateData{
+		Seq:    atomic.AddInt64(&g.seq, 1),
+		Time:   time.Now(),
+		Worker: worker,
+	}
+	err := g.template.Execute(&buf, data)
+	if err != nil {
+		return logevent.LogEvent{}, err
+	}
+	var logEvent logevent.LogEvent
+	if g.ndjson {
+		logEvent, err = decodeNDJSON(buf.Bytes())
+		if err != nil {
+			return logevent.LogEvent{}, err
+		}
+	} else {
+		logEvent.Content.Event = buf.String()
+	}
+	err = g.evFlags.apply(&logEvent)
+	return logEvent, err
+}
+
+func (g *generator) work(worker int, sender logevent.MessageSender, jobs <-chan struct{}) workerStats {
+	stats := workerStats{errors: make(map[string]int)}
+	for range jobs {
+		logEvent, err := g.render(worker)
+		if err == nil {
+			began := time.Now()
+			err = sender.SendMessage(logEvent)
+			stats.latencies = append(stats.latencies, time.Since(began))
+		}
+		if err != nil {
+			stats.errors[err.Error()]++
+			stats.failed++
+		} else {
+			stats.sent++
+		}
+	}
+	return stats
+}
+
+// print writes the throughput, latency percentiles and errors of each worker's sender, then totals.
+func (r generateReport) print(w io.Writer) {
+	var total workerStats
+	total.errors = make(map[string]int)
+	for i, stats := range r.workers {
+		fmt.Fprintf(w, "sender %d: %d sent, %d failed, latency %s\n",
+			i+1, stats.sent, stats.failed, latencySummary(stats.latencies))
+		printErrors(w, stats.errors)
+		total.sent += stats.sent
+		total.failed += stats.failed
+		total.latencies = append(total.latencies, stats.latencies...)
+		for msg, n := range stats.errors {
+			total.errors[msg] += n
+		}
+	}
+	seconds := r.elapsed.Seconds()
+	rate := 0.0
+	if seconds > 0 {
+		rate = float64(total.sent) / seconds
+	}
+	fmt.Fprintf(w, "total: %d sent, %d failed in %v (%.1f events/s), latency %s\n",
+		total.sent, total.failed, r.elapsed.Round(time.Millisecond), rate, latencySummary(total.latencies))
+	if len(r.workers) > 1 {
+		printErrors(w, total.errors)
+	}
+}
+
+func latencySummary(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "n/a"
+	}
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return fmt.Sprintf("p50=%v p90=%v p99=%v max=%v",
+		percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[len(sorted)-1])
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func printErrors(w io.Writer, counts map[string]int) {
+	msgs := make([]string, 0, len(counts))
+	for msg := range counts {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	for _, msg := range msgs {
+		fmt.Fprintf(w, "  %d x %s\n", counts[msg], msg)
+	}
+}
+
+func cmdGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	var evFlags eventFlags
+	evFlags.register(fs)
+	duration := fs.Duration("duration", 10*time.Second, "stop after this long (0 to run until interrupted)")
+	templateFile := fs.String("file", "", "read the template from this file")
+	ndjson := fs.Bool("ndjson", false, "the template renders a JSON LogEvent or MessageContent")
+	rate := fs.Float64("rate", 0, "target events per second across all workers (0 for no limit)")
+	workers := fs.Int("workers", 1, "number of concurrent workers, each with its own sender")
+	fs.Parse(args)
+
+	var text string
+	if *templateFile != "" {
+		b, err := ioutil.ReadFile(*templateFile)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	} else if fs.NArg() == 1 {
+		text = fs.Arg(0)
+	} else {
+		return errors.New("usage: generate [flags] TEMPLATE, or generate -file FILE [flags]")
+	}
+	tmpl, err := parseEventTemplate(text)
+	if err != nil {
+		return fmt.Errorf("parsing template: %v", err)
+	}
+
+	g := generator{
+		duration:  *duration,
+		evFlags:   &evFlags,
+		newSender: newSender,
+		ndjson:    *ndjson,
+		rate:      *rate,
+		template:  tmpl,
+		workers:   *workers,
+	}
+	report, err := g.run(stopOnSignal())
+	if err != nil {
+		return fmt.Errorf("initializing output: %v", err)
+	}
+	report.print(os.Stderr)
+	return nil
+}
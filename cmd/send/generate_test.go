@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"github.com/djschaap/logevent"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseEventTemplate(t *testing.T) {
+	tmpl, err := parseEventTemplate(`{{.Seq}} {{randInt 5 5}} {{choice "a"}} {{randHex 5 | len}} {{uuid}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, templateData{Seq: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^7 5 a 5 [0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !re.MatchString(buf.String()) {
+		t.Errorf("unexpected rendering %q", buf.String())
+	}
+
+	_, err = parseEventTemplate(`{{bogus}}`)
+	if err == nil {
+		t.Error("expected error from unknown function but got nil")
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	var mtx sync.Mutex
+	var senders []*fakeSender
+	tmpl, _ := parseEventTemplate(`{"event":"e{{.Seq}}","host":"w{{.Worker}}"}`)
+	g := generator{
+		duration: 200 * time.Millisecond,
+		evFlags:  newEventFlags(t, "-sourcetype", "st1"),
+		newSender: func() (logevent.MessageSender, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			s := &fakeSender{failOn: "e3"}
+			senders = append(senders, s)
+			return s, nil
+		},
+		ndjson:   true,
+		rate:     100,
+		template: tmpl,
+		workers:  2,
+	}
+	report, err := g.run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(senders) != 2 {
+		t.Fatalf("expected 2 senders, got %d", len(senders))
+	}
+	sent := len(senders[0].received) + len(senders[1].received)
+	// 100/s for 200ms, less the failure
+	if sent < 10 || sent > 25 {
+		t.Errorf("expected about 19 events, got %d", sent)
+	}
+	if senders[0].received[0].Content.Sourcetype != "st1" {
+		t.Errorf("expected flags to be applied, got %#v", senders[0].received[0])
+	}
+
+	var out bytes.Buffer
+	report.print(&out)
+	for _, expect := range []string{"sender 1: ", "sender 2: ", "1 x down", "p99="} {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("expected report to contain %q, got %q", expect, out.String())
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	if p := percentile(sorted, 50); p != 50 {
+		t.Errorf("expected p50=50, got %v", p)
+	}
+	if p := percentile(sorted, 99); p != 99 {
+		t.Errorf("expected p99=99, got %v", p)
+	}
+	if p := percentile(sorted[:1], 90); p != 1 {
+		t.Errorf("expected p90=1, got %v", p)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/djschaap/logevent"
 	"github.com/djschaap/logevent/flagarray"
 	"github.com/djschaap/logevent/fromenv"
-	"github.com/joho/godotenv"
+	"github.com/djschaap/logevent/internal/envfile"
 	"log"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -19,44 +24,252 @@ var (
 	version string
 )
 
+// eventFlags holds the command-line flags which set LogEvent attributes and content properties.
+type eventFlags struct {
+	customerCode      *string
+	epoch             *int64
+	fields            flagarray.KeyValues
+	host              *string
+	index             *string
+	source            *string
+	sourceEnvironment *string
+	sourcetype        *string
+	time              *string
+}
+
+// apply sets each property given on the command line.
+func (f *eventFlags) apply(logEvent *logevent.LogEvent) error {
+	if *f.customerCode != "" {
+		logEvent.Attributes.CustomerCode = *f.customerCode
+	}
+	if *f.host != "" {
+		logEvent.Attributes.Host = *f.host
+		logEvent.Content.Host = *f.host
+	}
+	if *f.index != "" {
+		logEvent.Content.Index = *f.index
+	}
+	if *f.source != "" {
+		logEvent.Attributes.Source = *f.source
+		logEvent.Content.Source = *f.source
+	}
+	if *f.sourceEnvironment != "" {
+		logEvent.Attributes.SourceEnvironment = *f.sourceEnvironment
+	}
+	if *f.sourcetype != "" {
+		logEvent.Attributes.Sourcetype = *f.sourcetype
+		logEvent.Content.Sourcetype = *f.sourcetype
+	}
+	if *f.epoch > 0 {
+		t := time.Unix(*f.epoch, 0)
+		logEvent.Content.Time = t
+	} else if *f.time != "" {
+		t, err := time.Parse(time.RFC3339, *f.time)
+		if err != nil {
+			return err
+		}
+		logEvent.Content.Time = t
+	}
+
+	if len(f.fields) > 0 && logEvent.Content.Fields == nil {
+		logEvent.Content.Fields = make(map[string]interface{})
+	}
+	for _, kv := range f.fields {
+		logEvent.Content.Fields[kv.Key] = kv.Value
+	}
+	return nil
+}
+
+func (f *eventFlags) register(fs *flag.FlagSet) {
+	f.customerCode = fs.String("customer", "", "set customer code attribute")
+	f.epoch = fs.Int64("epoch", 0, "time_t/epoch, as 64-bit int")
+	fs.Var(&f.fields, "field", "field value, as name=value or name:type=value (type: string, int, float, bool, json); value @file reads file; may be repeated")
+	f.host = fs.String("host", "", "set host attribute")
+	f.index = fs.String("index", "", "set index attribute")
+	f.source = fs.String("source", "", "source attribute")
+	f.sourceEnvironment = fs.String("sourceenvironment", "", "sourceenvironment attribute")
+	f.sourcetype = fs.String("sourcetype", "", "sourcetype attribute")
+	f.time = fs.String("time", "", "time, as ISO 8601/RFC 3339")
+}
+
 func printVersion() {
 	fmt.Println("logevent send  Version:",
 		version, " Commit:", commit,
 		" Built at:", buildDt)
 }
 
-func main() {
-	printVersion()
+// stopOnSignal returns a channel which is closed on SIGINT or SIGTERM.
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		close(stop)
+	}()
+	return stop
+}
 
-	eventCount := flag.Int("count", 1, "send N events")
-	repeatDelay := flag.Int("delay", 1, "delay N seconds between events")
-	customerCode := flag.String("customer", "", "set customer code attribute")
-	epochAttr := flag.Int64("epoch", 0, "time_t/epoch, as 64-bit int")
-	var fieldArgs flagarray.StringArray
-	flag.Var(&fieldArgs, "field", "field value, as fieldName=value, may be repeated")
-	hostAttr := flag.String("host", "", "set host attribute")
-	indexAttr := flag.String("index", "", "set index attribute")
-	sourceAttr := flag.String("source", "", "source attribute")
-	sourceEnvironmentAttr := flag.String("sourceenvironment", "", "sourceenvironment attribute")
-	sourcetypeAttr := flag.String("sourcetype", "", "sourcetype attribute")
-	timeAttr := flag.String("time", "", "time, as ISO 8601/RFC 3339")
+// command is one send subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"check-config", "validate output configuration, optionally probing the output", cmdCheckConfig},
+	{"event", "send one event (or -count events) from the command line", cmdEvent},
+	{"generate", "send synthetic events rendered from a template", cmdGenerate},
+	{"replay", "replay a recorded NDJSON file of LogEvents", cmdReplay},
+	{"stdin", "send each line of standard input as an event", cmdStdin},
+	{"version", "print version and exit", cmdVersion},
+}
+
+// senderOverrides are global flags which override the environment variables read by fromenv.
+var senderOverrides = []struct {
+	flag  string
+	env   string
+	usage string
+}{
+	{"amqp-exchange", "AMQP_EXCHANGE", "sendamqp exchange (AMQP_EXCHANGE)"},
+	{"amqp-routing-key", "AMQP_ROUTING_KEY", "sendamqp routing key (AMQP_ROUTING_KEY)"},
+	{"amqp-ttl", "AMQP_TTL", "sendamqp message TTL, in seconds (AMQP_TTL)"},
+	{"amqp-url", "AMQP_URL", "sendamqp connection URL (AMQP_URL)"},
+	{"config", "SENDER_CONFIG", "config file with named outputs (SENDER_CONFIG)"},
+	{"hec-token", "HEC_TOKEN", "sendhec token (HEC_TOKEN)"},
+	{"hec-url", "HEC_URL", "sendhec URL (HEC_URL)"},
+	{"output", "SENDER_OUTPUT", "output to use from the config file (SENDER_OUTPUT)"},
+	{"sender", "SENDER_PACKAGE", "output package: senddump, sendamqp, sendhec or sendsns (SENDER_PACKAGE)"},
+	{"sender-url", "SENDER_URL", "destination URL selecting the sender and its options (SENDER_URL)"},
+	{"sns-topic", "AWS_SNS_TOPIC", "sendsns topic ARN (AWS_SNS_TOPIC)"},
+}
+
+// newSender returns an unopened sender configured from the environment.
+// It is a variable so tests can replace it.
+var newSender = fromenv.GetMessageSenderFromEnv
+
+// openSender returns an opened sender configured from the environment.
+func openSender() (logevent.MessageSender, error) {
+	sender, err := newSender()
+	if err != nil {
+		return nil, fmt.Errorf("initializing output: %v", err)
+	}
+	err = sender.OpenSvc()
+	if err != nil {
+		return nil, fmt.Errorf("from OpenSvc: %v", err)
+	}
+	return sender, nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [global flags] COMMAND [flags] [args]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s COMMAND -h' for command flags.\n\nglobal flags:\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	dryRunOutput := flag.Bool("dry-run", false, "print what would be sent for each event, without connecting to the output")
+	envFile := flag.String("env-file", ".env", "load environment variables from this file, if it exists")
+	hecInsecure := flag.Bool("hec-insecure", false, "sendhec: skip TLS certificate verification (HEC_INSECURE)")
+	overrides := make(map[string]*string)
+	for _, o := range senderOverrides {
+		overrides[o.flag] = flag.String(o.flag, "", o.usage)
+	}
+	trace := flag.Bool("trace", false, "enable sender trace output (SENDER_TRACE)")
 	printVersion := flag.Bool("v", false, "print version and exit")
+	flag.Usage = usage
 	flag.Parse()
 	if *printVersion {
+		cmdVersion(nil)
 		os.Exit(0)
 	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
 
-	err := godotenv.Load()
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	err := envfile.Load(*envFile, set["env-file"])
 	if err != nil {
-		log.Fatal("Error loading .env file:", err)
+		log.Fatal(err)
 	}
-	sender, err := fromenv.GetMessageSenderFromEnv()
-	if err != nil {
-		log.Fatal("Error initializing output:", err)
+	for _, o := range senderOverrides {
+		if set[o.flag] {
+			os.Setenv(o.env, *overrides[o.flag])
+		}
 	}
-	err = sender.OpenSvc()
+	if set["hec-insecure"] {
+		if *hecInsecure {
+			os.Setenv("HEC_INSECURE", "1")
+		} else {
+			os.Unsetenv("HEC_INSECURE")
+		}
+	}
+	if *trace {
+		os.Setenv("SENDER_TRACE", "1")
+	}
+	if *dryRunOutput {
+		newSender = dryRun(newSender, os.Stdout)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			err = c.run(flag.Args()[1:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func cmdCheckConfig(args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	probe := fs.Bool("probe", false, "also check that the output is reachable and accepts the credentials (sends no events)")
+	fs.Parse(args)
+
+	report := fromenv.Validate(*probe)
+	fmt.Print(report)
+	if report.HasErrors() {
+		return errors.New("configuration has errors")
+	}
+	fmt.Println("configuration OK")
+	return nil
+}
+
+func cmdEvent(args []string) error {
+	fs := flag.NewFlagSet("event", flag.ExitOnError)
+	eventCount := fs.Int("count", 1, "send N events")
+	repeatDelay := fs.Int("delay", 1, "delay N seconds between events")
+	var evFlags eventFlags
+	evFlags.register(fs)
+	jsonEvent := fs.Bool("json", false, "MESSAGE is a JSON object, sent as structured event content")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: event [flags] MESSAGE")
+	}
+	var messageContent interface{} = fs.Arg(0)
+	if *jsonEvent {
+		var err error
+		messageContent, err = decodeJSONObject(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("-json: %v", err)
+		}
+	}
+
+	sender, err := openSender()
 	if err != nil {
-		log.Fatal("Error from OpenSvc:", err)
+		return err
 	}
 	defer sender.CloseSvc()
 
@@ -67,58 +280,43 @@ func main() {
 			time.Sleep(time.Duration(*repeatDelay) * time.Second)
 		}
 
-		messageContent := flag.Arg(0)
 		logEvent := logevent.LogEvent{
 			Content: logevent.MessageContent{
 				Event: messageContent,
 			},
 		}
-		if *customerCode != "" {
-			logEvent.Attributes.CustomerCode = *customerCode
-		}
-		if *hostAttr != "" {
-			logEvent.Attributes.Host = *hostAttr
-			logEvent.Content.Host = *hostAttr
-		}
-		if *indexAttr != "" {
-			logEvent.Content.Index = *indexAttr
-		}
-		if *sourceAttr != "" {
-			logEvent.Attributes.Source = *sourceAttr
-			logEvent.Content.Source = *sourceAttr
-		}
-		if *sourceEnvironmentAttr != "" {
-			logEvent.Attributes.SourceEnvironment = *sourceEnvironmentAttr
-		}
-		if *sourcetypeAttr != "" {
-			logEvent.Attributes.Sourcetype = *sourcetypeAttr
-			logEvent.Content.Sourcetype = *sourcetypeAttr
-		}
-		if *epochAttr > 0 {
-			t := time.Unix(*epochAttr, 0)
-			logEvent.Content.Time = t
-		} else if *timeAttr != "" {
-			t, err := time.Parse(time.RFC3339, *timeAttr)
-			if err != nil {
-				log.Fatal(err)
-			}
-			logEvent.Content.Time = t
-		}
-
-		logEvent.Content.Fields = make(map[string]interface{})
-		for _, rawPair := range fieldArgs {
-			re := regexp.MustCompile(`(\S+?)=(.+)`)
-			kv := re.FindStringSubmatch(rawPair)
-			if len(kv) < 2 {
-				log.Fatal("unable to parse field/value: ", rawPair)
-			}
-			//log.Printf("field: k=%s v=%s\n", kv[1], kv[2]) // DEBUG
-			logEvent.Content.Fields[kv[1]] = kv[2]
+		err = evFlags.apply(&logEvent)
+		if err != nil {
+			return err
 		}
 
 		err = sender.SendMessage(logEvent)
 		if err != nil {
-			log.Fatal("Error from SendMessage: ", err)
+			return fmt.Errorf("from SendMessage: %v", err)
 		}
 	}
+	return nil
+}
+
+// decodeJSONObject decodes a single JSON object, keeping numbers as json.Number.
+func decodeJSONObject(s string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	err := d.Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.New("expected a JSON object")
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+	return obj, nil
+}
+
+func cmdVersion(args []string) error {
+	printVersion()
+	return nil
 }
@@ -1 +1,52 @@
 package main
+
+import (
+	"encoding/json"
+	"github.com/djschaap/logevent"
+	"testing"
+	"time"
+)
+
+func TestEventFlagsApply(t *testing.T) {
+	f := newEventFlags(t, "-customer", "c1", "-host", "h1", "-epoch", "1577836800", "-field", "a=A", "-field", "b=x=y", "-field", "n:int=5")
+	var logEvent logevent.LogEvent
+	err := f.apply(&logEvent)
+	if err != nil {
+		t.Fatalf("apply() returned unexpected error %v", err)
+	}
+	if logEvent.Attributes.CustomerCode != "c1" || logEvent.Attributes.Host != "h1" || logEvent.Content.Host != "h1" {
+		t.Errorf("incorrect attributes, got %#v", logEvent)
+	}
+	if !logEvent.Content.Time.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("incorrect time, got %v", logEvent.Content.Time)
+	}
+	if logEvent.Content.Fields["a"] != "A" || logEvent.Content.Fields["b"] != "x=y" || logEvent.Content.Fields["n"] != int64(5) {
+		t.Errorf("incorrect fields, got %#v", logEvent.Content.Fields)
+	}
+
+	t.Run("bad time",
+		func(t *testing.T) {
+			f := newEventFlags(t, "-time", "yesterday")
+			err := f.apply(&logevent.LogEvent{})
+			if err == nil {
+				t.Error("expected error from invalid -time but got nil")
+			}
+		},
+	)
+}
+
+func TestDecodeJSONObject(t *testing.T) {
+	obj, err := decodeJSONObject(`{"a":1,"b":{"c":[true]}}`)
+	if err != nil {
+		t.Fatalf("decodeJSONObject() returned unexpected error %v", err)
+	}
+	if obj["a"] != json.Number("1") {
+		t.Errorf("expected a=1, got %#v", obj)
+	}
+	for _, input := range []string{``, `null`, `[1]`, `"s"`, `{"a":1} {}`, `{`} {
+		_, err := decodeJSONObject(input)
+		if err == nil {
+			t.Errorf("expected error from decodeJSONObject(%q) but got nil", input)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// replayer sends a recorded NDJSON stream of LogEvents, optionally preserving
+// the gaps between their Content.Time values.
+type replayer struct {
+	evFlags     *eventFlags
+	offset      int           // skip lines up to and including this line number
+	progress    time.Duration // report progress this often; 0 to disable
+	report      io.Writer
+	rewriteTime bool // shift Content.Time so the first replayed event is sent "now"
+	sender      logevent.MessageSender
+	speed       float64 // 1 preserves original gaps, 2 halves them, etc.; 0 sends as fast as possible
+}
+
+// replayResult summarizes a replay; Line is the last line number processed.
+type replayResult struct {
+	Failed      int
+	Interrupted bool
+	Line        int
+	Sent        int
+}
+
+// run replays r until EOF or until stop is closed.
+func (p *replayer) run(r io.Reader, stop <-chan struct{}) replayResult {
+	var result replayResult
+	lines := make(chan numberedLine)
+	go scanLines(r, lines)
+
+	var firstTime, start time.Time
+	lastProgress := time.Now()
+	for line := range lines {
+		select {
+		case <-stop:
+			result.Interrupted = true
+			return result
+		default:
+		}
+		if line.n <= p.offset {
+			result.Line = line.n
+			continue
+		}
+		if line.err != nil {
+			p.fail(line.n, line.err)
+			result.Failed++
+			result.Line = line.n
+			continue
+		}
+		if strings.TrimSpace(line.text) == "" {
+			result.Line = line.n
+			continue
+		}
+
+		logEvent, err := decodeNDJSON([]byte(line.text))
+		if err == nil {
+			err = p.evFlags.apply(&logEvent)
+		}
+		if err != nil {
+			p.fail(line.n, err)
+			result.Failed++
+			result.Line = line.n
+			continue
+		}
+
+		origTime := logEvent.Content.Time
+		if start.IsZero() {
+			start = time.Now()
+			firstTime = origTime
+		}
+		due := start
+		if p.speed > 0 && !origTime.IsZero() && !firstTime.IsZero() {
+			due = start.Add(time.Duration(float64(origTime.Sub(firstTime)) / p.speed))
+			if !p.wait(time.Until(due), stop) {
+				result.Interrupted = true
+				return result
+			}
+		}
+		if p.rewriteTime && !origTime.IsZero() {
+			if p.speed > 0 && !firstTime.IsZero() {
+				logEvent.Content.Time = due
+			} else {
+				logEvent.Content.Time = time.Now()
+			}
+		}
+
+		err = p.sender.SendMessage(logEvent)
+		if err != nil {
+			p.fail(line.n, err)
+			result.Failed++
+		} else {
+			result.Sent++
+		}
+		result.Line = line.n
+
+		if p.progress > 0 && time.Since(lastProgress) >= p.progress {
+			lastProgress = time.Now()
+			fmt.Fprintf(p.report, "replay: line %d, %d sent, %d failed\n", result.Line, result.Sent, result.Failed)
+		}
+	}
+	return result
+}
+
+func (p *replayer) fail(n int, err error) {
+	fmt.Fprintf(p.report, "line %d: %v\n", n, err)
+}
+
+// wait sleeps for d, returning false if stop was closed first.
+func (p *replayer) wait(d time.Duration, stop <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func cmdReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var evFlags eventFlags
+	evFlags.register(fs)
+	offset := fs.Int("offset", 0, "skip lines up to and including line N")
+	progress := fs.Duration("progress", 10*time.Second, "report progress this often (0 to disable)")
+	rewriteTime := fs.Bool("rewrite-time", false, "shift event times so the first event is sent now")
+	speed := fs.Float64("speed", 1, "divide original gaps between events by N (0 sends as fast as possible)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [flags] FILE (- for stdin)")
+	}
+	if *speed < 0 {
+		return errors.New("-speed must not be negative")
+	}
+	input := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	sender, err := openSender()
+	if err != nil {
+		return err
+	}
+	defer sender.CloseSvc()
+
+	p := replayer{
+		evFlags:     &evFlags,
+		offset:      *offset,
+		progress:    *progress,
+		report:      os.Stderr,
+		rewriteTime: *rewriteTime,
+		sender:      sender,
+		speed:       *speed,
+	}
+	result := p.run(input, stopOnSignal())
+	log.Printf("replay: line %d, sent %d events, %d failed\n", result.Line, result.Sent, result.Failed)
+	if result.Interrupted {
+		return fmt.Errorf("replay interrupted; resume with -offset %d", result.Line)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d lines failed", result.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const replayInput = `{"Content":{"event":"one","time":"2020-01-01T00:00:00Z"}}
+{"Content":{"event":"two","time":"2020-01-01T00:00:01Z"}}
+not json
+{"Content":{"event":"four","time":"2020-01-01T00:00:02Z"}}
+`
+
+func TestReplayer(t *testing.T) {
+	sender := &fakeSender{}
+	report := &bytes.Buffer{}
+	p := replayer{
+		evFlags: newEventFlags(t),
+		report:  report,
+		sender:  sender,
+		speed:   20,
+	}
+	begin := time.Now()
+	result := p.run(strings.NewReader(replayInput), nil)
+	elapsed := time.Since(begin)
+	if result.Sent != 3 || result.Failed != 1 || result.Line != 4 || result.Interrupted {
+		t.Errorf("unexpected result %#v", result)
+	}
+	// two seconds of recorded gaps at 20x
+	if elapsed < 90*time.Millisecond {
+		t.Errorf("expected gaps to be preserved, replay took %v", elapsed)
+	}
+	if !strings.HasPrefix(report.String(), "line 3: ") {
+		t.Errorf("unexpected report %q", report.String())
+	}
+	if sender.received[0].Content.Time.Year() != 2020 {
+		t.Errorf("expected original time, got %v", sender.received[0].Content.Time)
+	}
+}
+
+func TestReplayer_offsetAndRewrite(t *testing.T) {
+	sender := &fakeSender{}
+	p := replayer{
+		evFlags:     newEventFlags(t),
+		offset:      3,
+		report:      &bytes.Buffer{},
+		rewriteTime: true,
+		sender:      sender,
+	}
+	begin := time.Now()
+	result := p.run(strings.NewReader(replayInput), nil)
+	if result.Sent != 1 || result.Failed != 0 || result.Line != 4 {
+		t.Errorf("unexpected result %#v", result)
+	}
+	if len(sender.received) != 1 || sender.received[0].Content.Event != "four" {
+		t.Fatalf("unexpected events %#v", sender.received)
+	}
+	if sender.received[0].Content.Time.Before(begin) {
+		t.Errorf("expected rewritten time, got %v", sender.received[0].Content.Time)
+	}
+}
+
+func TestReplayer_interrupted(t *testing.T) {
+	sender := &fakeSender{}
+	p := replayer{
+		evFlags: newEventFlags(t),
+		report:  &bytes.Buffer{},
+		sender:  sender,
+		speed:   1,
+	}
+	stop := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+	result := p.run(strings.NewReader(replayInput), stop)
+	if !result.Interrupted || result.Line != 1 || result.Sent != 1 {
+		t.Errorf("unexpected result %#v", result)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/multiline"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxLineSize is the longest line accepted on standard input.
+const maxLineSize = 1024 * 1024
+
+// streamer sends each line (or multi-line record) read from a stream as an event,
+// using a single sender session for the whole stream.
+type streamer struct {
+	evFlags   *eventFlags
+	multiline *multiline.Config
+	ndjson    bool
+	report    io.Writer
+	sender    logevent.MessageSender
+}
+
+type numberedLine struct {
+	err  error
+	n    int
+	text string
+}
+
+// run reads r until EOF, returning the number of events sent and the number of lines which failed.
+// Each failure is reported, with its line number, to s.report.
+func (s *streamer) run(r io.Reader) (sent, failed int) {
+	lines := make(chan numberedLine)
+	go scanLines(r, lines)
+
+	if s.multiline == nil || s.ndjson {
+		for line := range lines {
+			if line.err != nil {
+				s.fail(line.n, line.err)
+				failed++
+				continue
+			}
+			if s.ndjson && strings.TrimSpace(line.text) == "" {
+				continue
+			}
+			if s.send(line.n, line.text) {
+				sent++
+			} else {
+				failed++
+			}
+		}
+		return
+	}
+
+	agg := multiline.New(*s.multiline)
+	var startLine int
+	emit := func(record string) {
+		if s.send(startLine, record) {
+			sent++
+		} else {
+			failed++
+		}
+	}
+	var tick <-chan time.Time
+	if agg.Timeout() > 0 {
+		ticker := time.NewTicker(agg.Timeout() / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if record, ok := agg.Flush(); ok {
+					emit(record)
+				}
+				return
+			}
+			if line.err != nil {
+				s.fail(line.n, line.err)
+				failed++
+				continue
+			}
+			if agg.Len() == 0 {
+				startLine = line.n
+			}
+			for _, record := range agg.Add(line.text) {
+				emit(record)
+				// any further record, and the pending one, began with this line
+				startLine = line.n
+			}
+		case now := <-tick:
+			if agg.Expired(now) {
+				record, _ := agg.Flush()
+				emit(record)
+			}
+		}
+	}
+}
+
+func (s *streamer) fail(n int, err error) {
+	fmt.Fprintf(s.report, "line %d: %v\n", n, err)
+}
+
+// send sends one line (or record) beginning at line number n, returning false if it failed.
+func (s *streamer) send(n int, text string) bool {
+	var logEvent logevent.LogEvent
+	var err error
+	if s.ndjson {
+		logEvent, err = decodeNDJSON([]byte(text))
+		if err != nil {
+			s.fail(n, err)
+			return false
+		}
+	} else {
+		logEvent.Content.Event = text
+	}
+	err = s.evFlags.apply(&logEvent)
+	if err != nil {
+		s.fail(n, err)
+		return false
+	}
+	err = s.sender.SendMessage(logEvent)
+	if err != nil {
+		s.fail(n, err)
+		return false
+	}
+	return true
+}
+
+// decodeNDJSON decodes one JSON object as a full LogEvent when it has top-level
+// Attributes or Content keys, or otherwise as a MessageContent.
+func decodeNDJSON(b []byte) (logevent.LogEvent, error) {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(b, &keys)
+	if err != nil {
+		return logevent.LogEvent{}, err
+	}
+	isLogEvent := false
+	for k := range keys {
+		if strings.EqualFold(k, "Attributes") || strings.EqualFold(k, "Content") {
+			isLogEvent = true
+			break
+		}
+	}
+
+	var logEvent logevent.LogEvent
+	if isLogEvent {
+		err = strictUnmarshal(b, &logEvent)
+	} else {
+		err = strictUnmarshal(b, &logEvent.Content)
+		logEvent.Attributes.Host = logEvent.Content.Host
+		logEvent.Attributes.Source = logEvent.Content.Source
+		logEvent.Attributes.Sourcetype = logEvent.Content.Sourcetype
+	}
+	if err != nil {
+		return logevent.LogEvent{}, err
+	}
+	if logEvent.Content.Event == nil && logEvent.Content.Metric == nil {
+		return logevent.LogEvent{}, errors.New("missing event or metric")
+	}
+	return logEvent, nil
+}
+
+func strictUnmarshal(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+// scanLines sends each line of r, numbered from 1, then closes lines.
+func scanLines(r io.Reader, lines chan<- numberedLine) {
+	defer close(lines)
+	br := bufio.NewReaderSize(r, 64*1024)
+	n := 0
+	for {
+		b, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// keep reading until end of line, then report a line which is too long
+			long := append([]byte{}, b...)
+			for err == bufio.ErrBufferFull {
+				b, err = br.ReadSlice('\n')
+				if len(long) <= maxLineSize {
+					long = append(long, b...)
+				}
+			}
+			b = long
+		}
+		if len(b) > 0 {
+			n++
+			if len(b) > maxLineSize {
+				lines <- numberedLine{err: fmt.Errorf("line longer than %d bytes", maxLineSize), n: n}
+			} else {
+				text := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+				lines <- numberedLine{n: n, text: text}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				lines <- numberedLine{err: err, n: n + 1}
+			}
+			return
+		}
+	}
+}
+
+func cmdStdin(args []string) error {
+	fs := flag.NewFlagSet("stdin", flag.ExitOnError)
+	var evFlags eventFlags
+	evFlags.register(fs)
+	var multilineFlags multiline.Flags
+	multilineFlags.Register(fs)
+	ndjson := fs.Bool("ndjson", false, "each line is a JSON LogEvent or MessageContent")
+	fs.Parse(args)
+	multilineConfig, err := multilineFlags.Config()
+	if err != nil {
+		return err
+	}
+
+	sender, err := openSender()
+	if err != nil {
+		return err
+	}
+	defer sender.CloseSvc()
+
+	s := streamer{
+		evFlags:   &evFlags,
+		multiline: multilineConfig,
+		ndjson:    *ndjson,
+		report:    os.Stderr,
+		sender:    sender,
+	}
+	sent, failed := s.run(os.Stdin)
+	log.Printf("stdin: sent %d events, %d failed\n", sent, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d lines failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/multiline"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type fakeSender struct {
+	failOn   string
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	if s.failOn != "" && logEvent.Content.Event == s.failOn {
+		return errors.New("down")
+	}
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+func newEventFlags(t *testing.T, args ...string) *eventFlags {
+	var f eventFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.register(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &f
+}
+
+func TestStreamer_lines(t *testing.T) {
+	sender := &fakeSender{failOn: "bad"}
+	report := &bytes.Buffer{}
+	s := streamer{
+		evFlags: newEventFlags(t, "-host", "h1", "-sourcetype", "st1"),
+		report:  report,
+		sender:  sender,
+	}
+	sent, failed := s.run(strings.NewReader("one\r\nbad\nthree"))
+	if sent != 2 || failed != 1 {
+		t.Errorf("expected sent=2 failed=1, got sent=%d failed=%d", sent, failed)
+	}
+	if len(sender.received) != 2 || sender.received[1].Content.Event != "three" {
+		t.Fatalf("unexpected events %#v", sender.received)
+	}
+	attrs := sender.received[0].Attributes
+	if attrs.Host != "h1" || attrs.Sourcetype != "st1" {
+		t.Errorf("incorrect attributes, got %#v", attrs)
+	}
+	if report.String() != "line 2: down\n" {
+		t.Errorf("unexpected report %q", report.String())
+	}
+}
+
+func TestStreamer_multiline(t *testing.T) {
+	sender := &fakeSender{failOn: "two\n  b"}
+	report := &bytes.Buffer{}
+	s := streamer{
+		evFlags:   newEventFlags(t),
+		multiline: &multiline.Config{Continue: regexp.MustCompile(`^\s`)},
+		report:    report,
+		sender:    sender,
+	}
+	sent, failed := s.run(strings.NewReader("one\n  a\ntwo\n  b\nthree\n"))
+	if sent != 2 || failed != 1 {
+		t.Errorf("expected sent=2 failed=1, got sent=%d failed=%d", sent, failed)
+	}
+	if report.String() != "line 3: down\n" {
+		t.Errorf("unexpected report %q", report.String())
+	}
+}
+
+func TestStreamer_ndjson(t *testing.T) {
+	sender := &fakeSender{}
+	report := &bytes.Buffer{}
+	s := streamer{
+		evFlags: newEventFlags(t, "-index", "idx1"),
+		ndjson:  true,
+		report:  report,
+		sender:  sender,
+	}
+	input := `{"event":"plain","host":"h1"}
+{"Attributes":{"customer_code":"c1","source":"s1"},"Content":{"event":{"k":"v"}}}
+
+not json
+{"host":"h2"}
+{"event":"x","bogus":1}
+{"metric":{"values":{"cpu.idle":95.5}}}
+`
+	sent, failed := s.run(strings.NewReader(input))
+	if sent != 3 || failed != 3 {
+		t.Errorf("expected sent=3 failed=3, got sent=%d failed=%d", sent, failed)
+	}
+	if len(sender.received) != 3 {
+		t.Fatalf("unexpected events %#v", sender.received)
+	}
+	first := sender.received[0]
+	if first.Content.Event != "plain" || first.Attributes.Host != "h1" || first.Content.Index != "idx1" {
+		t.Errorf("incorrect MessageContent line, got %#v", first)
+	}
+	second := sender.received[1]
+	if second.Attributes.CustomerCode != "c1" || second.Attributes.Source != "s1" {
+		t.Errorf("incorrect LogEvent line, got %#v", second)
+	}
+	if _, ok := second.Content.Event.(map[string]interface{}); !ok {
+		t.Errorf("expected object event, got %#v", second.Content.Event)
+	}
+	if third := sender.received[2]; third.Content.Metric == nil || third.Content.Metric.Values["cpu.idle"] != 95.5 {
+		t.Errorf("incorrect metric line, got %#v", third.Content.Metric)
+	}
+	for _, expect := range []string{"line 4: ", "line 5: missing event", "line 6: "} {
+		if !strings.Contains(report.String(), expect) {
+			t.Errorf("expected report to contain %q, got %q", expect, report.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent/fromenv"
+	"github.com/djschaap/logevent/internal/envfile"
+	"github.com/djschaap/logevent/internal/syslogd"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	buildDt string
+	commit  string
+	version string
+)
+
+func printVersion() {
+	fmt.Println("logevent syslogd  Version:",
+		version, " Commit:", commit,
+		" Built at:", buildDt)
+}
+
+func main() {
+	printVersion()
+
+	udpAddr := flag.String("udp", ":514", "UDP address to listen on; empty to disable")
+	tcpAddr := flag.String("tcp", ":514", "TCP address to listen on; empty to disable")
+	tlsAddr := flag.String("tls", "", "TLS address to listen on (e.g. :6514); empty to disable")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	sourcetype := flag.String("sourcetype", "syslog", "sourcetype of forwarded events")
+	printVersion := flag.Bool("v", false, "print version and exit")
+	flag.Parse()
+	if *printVersion {
+		os.Exit(0)
+	}
+
+	err := envfile.Load(".env", false)
+	if err != nil {
+		log.Fatal("Error loading .env file:", err)
+	}
+	sender, err := fromenv.GetMessageSenderFromEnv()
+	if err != nil {
+		log.Fatal("Error initializing output:", err)
+	}
+	err = sender.OpenSvc()
+	if err != nil {
+		log.Fatal("Error from OpenSvc:", err)
+	}
+	defer sender.CloseSvc()
+
+	s := syslogd.New(sender)
+	s.SetSourcetype(*sourcetype)
+	trace, _ := fromenv.GetenvBool("SENDER_TRACE")
+	s.SetTrace(trace)
+
+	errs := make(chan error, 3)
+	listening := 0
+	if *udpAddr != "" {
+		conn, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			log.Fatal("Error listening on UDP: ", err)
+		}
+		log.Println("syslogd listening on udp", *udpAddr)
+		go func() { errs <- s.ServeUDP(conn) }()
+		listening++
+	}
+	if *tcpAddr != "" {
+		l, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			log.Fatal("Error listening on TCP: ", err)
+		}
+		log.Println("syslogd listening on tcp", *tcpAddr)
+		go func() { errs <- s.ServeTCP(l) }()
+		listening++
+	}
+	if *tlsAddr != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("Error loading TLS certificate: ", err)
+		}
+		l, err := tls.Listen("tcp", *tlsAddr, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			log.Fatal("Error listening on TLS: ", err)
+		}
+		log.Println("syslogd listening on tls", *tlsAddr)
+		go func() { errs <- s.ServeTCP(l) }()
+		listening++
+	}
+	if listening == 0 {
+		log.Fatal("syslogd requires at least one of -udp, -tcp, -tls")
+	}
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Println("received", sig, "; shutting down")
+		s.Close()
+	}()
+
+	for i := 0; i < listening; i++ {
+		err := <-errs
+		if err != nil {
+			s.Close()
+			log.Fatal("Error from syslogd: ", err)
+		}
+	}
+}
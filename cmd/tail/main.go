@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/djschaap/logevent/fromenv"
+	"github.com/djschaap/logevent/internal/envfile"
+	"github.com/djschaap/logevent/internal/tail"
+	"github.com/djschaap/logevent/multiline"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	buildDt string
+	commit  string
+	version string
+)
+
+func printVersion() {
+	fmt.Println("logevent tail  Version:",
+		version, " Commit:", commit,
+		" Built at:", buildDt)
+}
+
+func main() {
+	printVersion()
+
+	checkpointFile := flag.String("checkpoint", "", "file in which read offsets are persisted")
+	hostAttr := flag.String("host", "", "set host attribute (default: this host's name)")
+	indexAttr := flag.String("index", "", "set index attribute")
+	var multilineFlags multiline.Flags
+	multilineFlags.Register(flag.CommandLine)
+	pollInterval := flag.Duration("poll", time.Second, "check files for new data this often")
+	sourcetypeAttr := flag.String("sourcetype", "", "sourcetype attribute")
+	startAtEnd := flag.Bool("start-at-end", false, "skip existing content of files without a checkpoint")
+	printVersion := flag.Bool("v", false, "print version and exit")
+	flag.Parse()
+	if *printVersion {
+		os.Exit(0)
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("usage: tail [flags] GLOB [GLOB...]")
+	}
+
+	err := envfile.Load(".env", false)
+	if err != nil {
+		log.Fatal("Error loading .env file:", err)
+	}
+	sender, err := fromenv.GetMessageSenderFromEnv()
+	if err != nil {
+		log.Fatal("Error initializing output:", err)
+	}
+	err = sender.OpenSvc()
+	if err != nil {
+		log.Fatal("Error from OpenSvc:", err)
+	}
+	defer sender.CloseSvc()
+
+	tailer, err := tail.New(sender, flag.Args())
+	if err != nil {
+		log.Fatal("Error initializing tail:", err)
+	}
+	if *checkpointFile != "" {
+		err = tailer.SetCheckpointFile(*checkpointFile)
+		if err != nil {
+			log.Fatal("Error loading checkpoint file:", err)
+		}
+	}
+	if *hostAttr != "" {
+		tailer.SetHost(*hostAttr)
+	}
+	multilineConfig, err := multilineFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if multilineConfig != nil {
+		tailer.SetMultiline(*multilineConfig)
+	}
+	tailer.SetIndex(*indexAttr)
+	tailer.SetPollInterval(*pollInterval)
+	tailer.SetSourcetype(*sourcetypeAttr)
+	tailer.SetStartAtEnd(*startAtEnd)
+	trace, _ := fromenv.GetenvBool("SENDER_TRACE")
+	tailer.SetTrace(trace)
+
+	stop := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Println("received", sig, "; shutting down")
+		close(stop)
+	}()
+
+	err = tailer.Run(stop)
+	if err != nil {
+		log.Fatal("Error from tail: ", err)
+	}
+}
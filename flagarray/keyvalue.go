@@ -0,0 +1,132 @@
+package flagarray
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// KeyValue is one key and its typed value.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// KeyValues is an ordered list of key/value pairs, used to store one or more values provided for a flag.
+//
+// Each value is given as KEY=VALUE or KEY:TYPE=VALUE, where TYPE is one of
+// string (the default), int, float, bool or json.
+// An empty VALUE must be typed, as in KEY:string=, so a missing value is not taken for an empty string.
+// A VALUE beginning with @ is read from the named file; use @@ for a literal leading @.
+// A key may only be given once.
+type KeyValues []KeyValue
+
+// keyValueTypes converts a VALUE to each supported TYPE.
+var keyValueTypes = map[string]func(string) (interface{}, error){
+	"bool": func(s string) (interface{}, error) {
+		return strconv.ParseBool(strings.TrimSpace(s))
+	},
+	"float": func(s string) (interface{}, error) {
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	},
+	"int": func(s string) (interface{}, error) {
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	},
+	"json": func(s string) (interface{}, error) {
+		var v interface{}
+		d := json.NewDecoder(strings.NewReader(s))
+		d.UseNumber()
+		err := d.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		if d.More() {
+			return nil, errors.New("unexpected data after JSON value")
+		}
+		return v, nil
+	},
+	"string": func(s string) (interface{}, error) {
+		return s, nil
+	},
+}
+
+// Get returns the value of key and whether it was set.
+func (kvs KeyValues) Get(key string) (interface{}, bool) {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Map returns the pairs as a map, or nil if there are none.
+func (kvs KeyValues) Map() map[string]interface{} {
+	if len(kvs) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+// Set parses and appends a KEY[:TYPE]=VALUE pair to a KeyValues.
+func (kvs *KeyValues) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return fmt.Errorf("%q is not KEY=VALUE or KEY:TYPE=VALUE", v)
+	}
+	key, raw := v[:i], v[i+1:]
+	typ := ""
+	if j := strings.LastIndex(key, ":"); j >= 0 {
+		if _, ok := keyValueTypes[key[j+1:]]; ok {
+			key, typ = key[:j], key[j+1:]
+		}
+	}
+	if key == "" || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid key in %q", v)
+	}
+	if typ == "" {
+		if raw == "" {
+			return fmt.Errorf("empty value in %q; use KEY:string= for an empty string", v)
+		}
+		typ = "string"
+	}
+	if _, ok := kvs.Get(key); ok {
+		return fmt.Errorf("duplicate key %s", key)
+	}
+
+	if strings.HasPrefix(raw, "@@") {
+		raw = raw[1:]
+	} else if strings.HasPrefix(raw, "@") {
+		b, err := ioutil.ReadFile(raw[1:])
+		if err != nil {
+			return fmt.Errorf("key %s: %v", key, err)
+		}
+		raw = string(b)
+	}
+	value, err := keyValueTypes[typ](raw)
+	if err != nil {
+		return fmt.Errorf("key %s: invalid %s value: %v", key, typ, err)
+	}
+	*kvs = append(*kvs, KeyValue{Key: key, Value: value})
+	return nil
+}
+
+func (kvs *KeyValues) String() string {
+	var buf bytes.Buffer
+	for i, kv := range *kvs {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		fmt.Fprintf(&buf, "%s=%v", kv.Key, kv.Value)
+	}
+	return buf.String()
+}
@@ -0,0 +1,106 @@
+package flagarray
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValuesSet(t *testing.T) {
+	f, err := ioutil.TempFile("", "logevent-flagarray-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("file\ncontent\n")
+	f.Close()
+
+	tests := []struct {
+		name   string
+		input  string
+		expect interface{}
+	}{
+		{"string", "k=v=w", "v=w"},
+		{"explicit string", "k:string=5", "5"},
+		{"empty string", "k:string=", ""},
+		{"int", "k:int=5", int64(5)},
+		{"float", "k:float=1.5", 1.5},
+		{"bool", "k:bool=true", true},
+		{"json array", "k:json=[1,\"a\"]", []interface{}{json.Number("1"), "a"}},
+		{"json object", "k:json={\"a\":null}", map[string]interface{}{"a": nil}},
+		{"unknown type suffix is part of key", "k:x=v", "v"},
+		{"file", "k=@" + f.Name(), "file\ncontent\n"},
+		{"literal @", "k=@@home", "@home"},
+	}
+	for _, test := range tests {
+		t.Run(test.name,
+			func(t *testing.T) {
+				kvs := KeyValues{}
+				err := kvs.Set(test.input)
+				if err != nil {
+					t.Fatalf("Set(%q) returned unexpected error %v", test.input, err)
+				}
+				if len(kvs) != 1 || !reflect.DeepEqual(kvs[0].Value, test.expect) {
+					t.Errorf("Set(%q): expected value %#v, got %#v", test.input, test.expect, kvs)
+				}
+			},
+		)
+	}
+
+	t.Run("typed value from file",
+		func(t *testing.T) {
+			ioutil.WriteFile(f.Name(), []byte("42\n"), 0644)
+			kvs := KeyValues{}
+			err := kvs.Set("n:int=@" + f.Name())
+			if err != nil {
+				t.Fatalf("Set() returned unexpected error %v", err)
+			}
+			if kvs[0].Key != "n" || kvs[0].Value != int64(42) {
+				t.Errorf("unexpected %#v", kvs)
+			}
+		},
+	)
+
+	for _, input := range []string{"novalue", "k=", "=v", "a b=v", "k:int=x", "k:bool=maybe", "k:json={", "k:json=1 2", "k=@/nonexistent/file"} {
+		t.Run("invalid "+input,
+			func(t *testing.T) {
+				kvs := KeyValues{}
+				if err := kvs.Set(input); err == nil {
+					t.Errorf("expected error from Set(%q) but got nil", input)
+				}
+			},
+		)
+	}
+
+	t.Run("duplicate key",
+		func(t *testing.T) {
+			kvs := KeyValues{}
+			kvs.Set("k=1")
+			err := kvs.Set("k:int=2")
+			if err == nil {
+				t.Error("expected error from duplicate key but got nil")
+			}
+			if len(kvs) != 1 {
+				t.Errorf("expected len(kvs) == 1 but got %d", len(kvs))
+			}
+		},
+	)
+}
+
+func TestKeyValuesMap(t *testing.T) {
+	kvs := KeyValues{}
+	if m := kvs.Map(); m != nil {
+		t.Errorf("expected nil map, got %#v", m)
+	}
+	kvs.Set("a=A")
+	kvs.Set("b:int=2")
+	expect := map[string]interface{}{"a": "A", "b": int64(2)}
+	if m := kvs.Map(); !reflect.DeepEqual(m, expect) {
+		t.Errorf("expected %#v, got %#v", expect, m)
+	}
+	if s := kvs.String(); s != "a=A b=2" {
+		t.Errorf("incorrect String() response, got %v", s)
+	}
+}
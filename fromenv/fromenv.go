@@ -1,22 +1,35 @@
 package fromenv
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/fromfile"
+	"github.com/djschaap/logevent/internal/credential"
+	"github.com/djschaap/logevent/internal/proxy"
 	"github.com/djschaap/logevent/internal/sendamqp"
 	"github.com/djschaap/logevent/internal/senddump"
 	"github.com/djschaap/logevent/internal/sendhec"
 	"github.com/djschaap/logevent/internal/sendsns"
+	"github.com/djschaap/logevent/internal/tlsconfig"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // os.Getenv mocking concept from alexellis
 // https://gist.github.com/alexellis/adc67eb022b7fdca31afc0de6529e5ea
 type anyEnv interface {
+	Environ() []string
 	Getenv(string) string
+	LookupEnv(string) (string, bool)
 	Setenv(string, string)
 	Unsetenv(string)
 }
@@ -25,10 +38,18 @@ type realEnv struct{}
 
 var env anyEnv
 
+func (realEnv) Environ() []string {
+	return os.Environ()
+}
+
 func (realEnv) Getenv(k string) string {
 	return os.Getenv(k)
 }
 
+func (realEnv) LookupEnv(k string) (string, bool) {
+	return os.LookupEnv(k)
+}
+
 func (realEnv) Setenv(k string, v string) {
 	os.Setenv(k, v)
 }
@@ -37,18 +58,50 @@ func (realEnv) Unsetenv(k string) {
 	os.Unsetenv(k)
 }
 
+// GetMessageSenderFromEnv returns a MessageSender configured by environment variables.
+// When SENDER_CONFIG names a config file (see package fromfile), the sender is built
+// from its output named by SENDER_OUTPUT, or its default output, and the other
+// sender variables are ignored; SENDER_TRACE still applies.
+// Otherwise, when SENDER_URL is set (see ParseSenderURL), it selects the sender and
+// its options, and the package-specific variables are ignored.
+// The TLS_ and PROXY_ variables apply to sendhec and sendamqp, unless SENDER_CONFIG is set.
 func GetMessageSenderFromEnv() (logevent.MessageSender, error) {
 	initEnv()
 	senderPackage := env.Getenv("SENDER_PACKAGE")
-	var traceOutput bool
-	if len(env.Getenv("SENDER_TRACE")) > 0 {
+	traceOutput, err := getenvBool("SENDER_TRACE")
+	if err != nil {
+		return nil, errors.New("FATAL: " + err.Error())
+	}
+	if traceOutput {
 		fmt.Println("*** SENDER_TRACE is enabled ***")
-		traceOutput = true
+	}
+
+	senderURL, senderURLProvider, err := getenvSecret("SENDER_URL")
+	if err != nil {
+		return nil, errors.New("FATAL: " + err.Error())
 	}
 
 	var sender logevent.MessageSender
-	if senderPackage == "sendamqp" {
-		amqpURL := buildAmqpUrl()
+	if configPath := env.Getenv("SENDER_CONFIG"); configPath != "" {
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			return nil, errors.New("FATAL: SENDER_CONFIG " + err.Error())
+		}
+		sender, err = cfg.NewSender(env.Getenv("SENDER_OUTPUT"))
+		if err != nil {
+			return nil, errors.New("FATAL: SENDER_CONFIG " + configPath + ": " + err.Error())
+		}
+	} else if senderURL != "" {
+		destination, err := ParseSenderURL(senderURL)
+		if err != nil {
+			return nil, errors.New("FATAL: SENDER_URL " + err.Error())
+		}
+		sender = destination.newSender(senderURLProvider)
+	} else if senderPackage == "sendamqp" {
+		amqpURL, urlProvider, err := amqpUrlFromEnv()
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
 		amqpExchange := env.Getenv("AMQP_EXCHANGE")
 		amqpRoutingKey := env.Getenv("AMQP_ROUTING_KEY")
 		amqpTtl := env.Getenv("AMQP_TTL")
@@ -56,17 +109,65 @@ func GetMessageSenderFromEnv() (logevent.MessageSender, error) {
 			log.Println("WARNING: sendamqp requires AMQP_ROUTING_KEY; continuing anyway")
 		}
 		amqpSender := sendamqp.New(amqpURL, amqpExchange, amqpRoutingKey, amqpTtl)
+		if urlProvider != nil {
+			amqpSender.SetURLProvider(urlProvider)
+		}
+		confirmTimeout, err := parseTimeout("AMQP_CONFIRM_TIMEOUT", env.Getenv("AMQP_CONFIRM_TIMEOUT"))
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		amqpSender.SetConfirm(confirmTimeout)
 		sender = amqpSender
 	} else if senderPackage == "sendhec" {
 		hecURL := env.Getenv("HEC_URL")
-		hecToken := env.Getenv("HEC_TOKEN")
+		hecToken, tokenProvider, err := getenvSecret("HEC_TOKEN")
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
 		if len(hecToken) <= 0 {
 			return nil, errors.New("FATAL: sendhec requires HEC_TOKEN")
 		}
+		hecURLs := splitList(hecURL)
+		if len(hecURLs) > 1 {
+			hecURL = hecURLs[0]
+		}
 		hecSender := sendhec.New(hecURL, hecToken)
-		if len(env.Getenv("HEC_INSECURE")) > 0 {
-			hecSender.SetHecInsecure(true)
+		if len(hecURLs) > 1 {
+			hecSender.SetURLs(hecURLs)
+		}
+		if tokenProvider != nil {
+			hecSender.SetTokenProvider(tokenProvider)
+		}
+		hecBalance, err := sendhec.ParseBalance(env.Getenv("HEC_BALANCE"))
+		if err != nil {
+			return nil, errors.New("FATAL: HEC_BALANCE=" + err.Error())
 		}
+		hecSender.SetBalance(hecBalance)
+		hecInsecure, err := getenvBool("HEC_INSECURE")
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		hecSender.SetHecInsecure(hecInsecure)
+		ackTimeout, ackResends, err := parseAck(
+			"HEC_ACK_TIMEOUT", env.Getenv("HEC_ACK_TIMEOUT"),
+			"HEC_ACK_RESENDS", env.Getenv("HEC_ACK_RESENDS"))
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		hecSender.SetAck(ackTimeout, ackResends)
+		hecRaw, err := getenvBool("HEC_RAW")
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		hecSender.SetRaw(hecRaw)
+		httpSettings, err := parseHTTPSettings(func(option string) (string, string) {
+			name := "HEC_" + strings.ToUpper(option)
+			return name, env.Getenv(name)
+		})
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		hecSender.SetHTTP(httpSettings)
 		sender = hecSender
 	} else if senderPackage == "sendsns" {
 		// github.com/aws/aws-sdk-go/aws reads env vars itself
@@ -85,54 +186,328 @@ func GetMessageSenderFromEnv() (logevent.MessageSender, error) {
 		return nil, errors.New("FATAL: SENDER_PACKAGE " + senderPackage + " is not valid")
 	}
 
+	if env.Getenv("SENDER_CONFIG") == "" {
+		tlsConfig, err := tlsFromEnv()
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		if c, ok := sender.(tlsConfigurer); ok && tlsConfig != nil {
+			c.SetTLSConfig(tlsConfig)
+		}
+		p, err := proxyFromEnv()
+		if err != nil {
+			return nil, errors.New("FATAL: " + err.Error())
+		}
+		if c, ok := sender.(proxyConfigurer); ok && p != nil {
+			c.SetProxy(p)
+		}
+	}
+
 	if traceOutput {
 		sender.SetTrace(true)
 	}
 	return sender, nil
 }
 
+// tlsConfigurer is implemented by senders which connect over TLS.
+type tlsConfigurer interface {
+	SetTLSConfig(*tls.Config)
+}
+
+// tlsSettingsFromEnv returns the TLS settings from the TLS_ variables.
+// TLS_CIPHER_SUITES is a comma-separated list.
+func tlsSettingsFromEnv() tlsconfig.Settings {
+	return tlsconfig.Settings{
+		CAFile:       env.Getenv("TLS_CA_FILE"),
+		CertFile:     env.Getenv("TLS_CERT_FILE"),
+		CipherSuites: splitList(env.Getenv("TLS_CIPHER_SUITES")),
+		KeyFile:      env.Getenv("TLS_KEY_FILE"),
+		MinVersion:   env.Getenv("TLS_MIN_VERSION"),
+		ServerName:   env.Getenv("TLS_SERVER_NAME"),
+	}
+}
+
+// tlsFromEnv returns the TLS configuration from the TLS_ variables; nil when none are set.
+func tlsFromEnv() (*tls.Config, error) {
+	config, err := tlsSettingsFromEnv().Config()
+	if err != nil {
+		return nil, fmt.Errorf("TLS settings: %v", err)
+	}
+	return config, nil
+}
+
+// proxyConfigurer is implemented by senders which may connect through a proxy.
+type proxyConfigurer interface {
+	SetProxy(proxy.Func)
+}
+
+// proxyFromEnv returns the proxy set by PROXY_URL (or PROXY_URL_FILE), excluding the targets
+// in NO_PROXY; nil when it is not set, so the senders use HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func proxyFromEnv() (proxy.Func, error) {
+	proxyURL, _, err := getenvSecret("PROXY_URL")
+	if err != nil || proxyURL == "" {
+		return nil, err
+	}
+	u, err := proxy.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY_URL %v", err)
+	}
+	noProxy := env.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = env.Getenv("no_proxy")
+	}
+	return proxy.Fixed(u, noProxy), nil
+}
+
+// amqpUrlFromEnv returns the AMQP URL, like buildAmqpUrl, but with AMQP_URL or
+// AMQP_PASSWORD read from the file named by AMQP_URL_FILE or AMQP_PASSWORD_FILE.
+// When either file is used, it also returns a Provider which re-reads the file,
+// so sendamqp picks up a rotated password when it reconnects.
+func amqpUrlFromEnv() (string, credential.Provider, error) {
+	_, urlProvider, err := getenvSecret("AMQP_URL")
+	if err != nil {
+		return "", nil, err
+	}
+	_, passwordProvider, err := getenvSecret("AMQP_PASSWORD")
+	if err != nil {
+		return "", nil, err
+	}
+	if urlProvider == nil && passwordProvider == nil {
+		return buildAmqpUrl(), nil, nil
+	}
+	provider := credential.Func(func() (string, error) {
+		amqpUrl := env.Getenv("AMQP_URL")
+		if urlProvider != nil {
+			v, err := urlProvider.Credential()
+			if err != nil {
+				return "", fmt.Errorf("AMQP_URL_FILE: %v", err)
+			}
+			amqpUrl = v
+		}
+		amqpPassword := env.Getenv("AMQP_PASSWORD")
+		if passwordProvider != nil {
+			v, err := passwordProvider.Credential()
+			if err != nil {
+				return "", fmt.Errorf("AMQP_PASSWORD_FILE: %v", err)
+			}
+			amqpPassword = v
+		}
+		return formatAmqpUrl(amqpUrl, amqpPassword), nil
+	})
+	amqpUrl, err := provider.Credential()
+	if err != nil {
+		return "", nil, err
+	}
+	return amqpUrl, provider, nil
+}
+
+// buildAmqpUrl returns AMQP_URL, if set, or a URL built from the separate
+// connection parameters and their defaults.
+// AMQP_PORT is omitted when unset, so the protocol's default port
+// (5672 for amqp, 5671 for amqps) is used.
 func buildAmqpUrl() string {
-	var amqpUrl string
-	amqpUrl = env.Getenv("AMQP_URL")
+	return formatAmqpUrl(env.Getenv("AMQP_URL"), env.Getenv("AMQP_PASSWORD"))
+}
+
+// formatAmqpUrl implements buildAmqpUrl, with the values of AMQP_URL and AMQP_PASSWORD given.
+func formatAmqpUrl(amqpUrl, amqpPassword string) string {
 	if len(amqpUrl) > 0 {
 		return amqpUrl
 	}
 
-	amqpHost := env.Getenv("AMQP_HOST")
-	amqpPassword := env.Getenv("AMQP_PASSWORD")
+	amqpHost := getenvDefault("AMQP_HOST", "localhost")
 	amqpPort := env.Getenv("AMQP_PORT")
-	amqpProtocol := env.Getenv("AMQP_PROTOCOL")
-	amqpUsername := env.Getenv("AMQP_USERNAME")
+	amqpProtocol := getenvDefault("AMQP_PROTOCOL", "amqp")
+	amqpUsername := getenvDefault("AMQP_USERNAME", "guest")
 	amqpVhost := env.Getenv("AMQP_VHOST")
 
-	var userPass string
+	var userInfo *url.Userinfo
 	if len(amqpPassword) > 0 {
-		userPass = fmt.Sprintf("%s:%s@", amqpUsername, amqpPassword)
+		userInfo = url.UserPassword(amqpUsername, amqpPassword)
 	} else {
-		userPass = fmt.Sprintf("%s@", amqpUsername)
+		userInfo = url.User(amqpUsername)
 	}
 
 	var hostPort string
 	if len(amqpPort) > 0 {
-		hostPort = fmt.Sprintf("%s:%s", amqpHost, amqpPort)
+		hostPort = net.JoinHostPort(amqpHost, amqpPort)
 	} else {
 		hostPort = amqpHost
 	}
-	amqpUrl = fmt.Sprintf("%s://%s%s", amqpProtocol, userPass, hostPort)
+	amqpUrl = fmt.Sprintf("%s://%s@%s", amqpProtocol, userInfo.String(), hostPort)
 	if len(amqpVhost) > 0 {
-		amqpUrl = amqpUrl + "/" + amqpVhost
+		amqpUrl = amqpUrl + "/" + url.PathEscape(amqpVhost)
 	}
 
 	return amqpUrl
 }
 
-func getenvBool(k string) bool {
+// getenvBool parses environment variable k as a boolean.
+// An unset or empty variable is false; otherwise the value must be
+// true/false, 1/0 or yes/no (in any case).
+func getenvBool(k string) (bool, error) {
 	initEnv()
 	v := env.Getenv(k)
+	b, ok := parseBool(v)
+	if !ok {
+		return false, fmt.Errorf("%s=%q is not a boolean; use true/false, 1/0 or yes/no", k, v)
+	}
+	return b, nil
+}
+
+// parseAck parses the sendhec indexer acknowledgment timeout (a duration, such as 30s;
+// empty disables acknowledgment) and the number of re-sends after a timeout (default 1).
+// Errors refer to the settings by the names given.
+func parseAck(timeoutName, timeout, resendsName, resends string) (time.Duration, int, error) {
+	d, err := parseTimeout(timeoutName, timeout)
+	if err != nil || d == 0 {
+		return 0, 0, err
+	}
+	if resends == "" {
+		return d, 1, nil
+	}
+	n, err := strconv.Atoi(resends)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("%s=%q is not a whole number", resendsName, resends)
+	}
+	return d, n, nil
+}
+
+// parseTimeout parses a timeout which enables a feature, such as the sendamqp confirm timeout:
+// a positive duration, such as 30s, or empty for 0 (disabled). Errors refer to it as name.
+func parseTimeout(name, timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s=%q is not a positive duration, such as 30s", name, timeout)
+	}
+	return d, nil
+}
+
+// httpOptions are the sendhec connection options read by parseHTTPSettings;
+// as variables, they are prefixed with HEC_ and upper case.
+var httpOptions = []string{"connect_timeout", "http2", "idle_timeout", "keep_alive", "max_idle_conns", "request_timeout", "response_timeout", "tls_timeout"}
+
+// parseHTTPSettings parses the sendhec connection options, starting from sendhec.DefaultHTTPSettings.
+// lookup returns the name by which errors refer to an option, and its value; empty is the default.
+// Timeouts are durations, such as 30s, where 0 is no limit; keep_alive and http2 are booleans.
+func parseHTTPSettings(lookup func(option string) (name, value string)) (sendhec.HTTPSettings, error) {
+	s := sendhec.DefaultHTTPSettings()
+	durations := map[string]*time.Duration{
+		"connect_timeout":  &s.ConnectTimeout,
+		"idle_timeout":     &s.IdleTimeout,
+		"request_timeout":  &s.RequestTimeout,
+		"response_timeout": &s.ResponseTimeout,
+		"tls_timeout":      &s.TLSTimeout,
+	}
+	for _, option := range httpOptions {
+		name, v := lookup(option)
+		if v == "" {
+			continue
+		}
+		if d, ok := durations[option]; ok {
+			var err error
+			*d, err = time.ParseDuration(v)
+			if err != nil || *d < 0 {
+				return s, fmt.Errorf("%s=%q is not a duration, such as 30s", name, v)
+			}
+			continue
+		}
+		switch option {
+		case "http2", "keep_alive":
+			b, ok := parseBool(v)
+			if !ok {
+				return s, fmt.Errorf("%s=%q is not a boolean; use true/false, 1/0 or yes/no", name, v)
+			}
+			if option == "http2" {
+				s.DisableHTTP2 = !b
+			} else {
+				s.DisableKeepAlive = !b
+			}
+		case "max_idle_conns":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return s, fmt.Errorf("%s=%q is not a whole number", name, v)
+			}
+			s.MaxIdleConns = n
+		}
+	}
+	return s, nil
+}
+
+// splitList splits a comma-separated list, such as of HEC URLs, ignoring spaces and empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseBool parses v as true/false, 1/0 or yes/no (in any case); empty is false.
+// It returns ok=false for any other value.
+func parseBool(v string) (b bool, ok bool) {
+	switch strings.ToLower(v) {
+	case "":
+		return false, true
+	case "1", "true", "yes":
+		return true, true
+	case "0", "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// GetenvBool parses environment variable k as a boolean, in the same way as the
+// variables read by GetMessageSenderFromEnv.
+func GetenvBool(k string) (bool, error) {
+	return getenvBool(k)
+}
+
+// getenvSecret returns the value of secret variable k or, when k_FILE is set,
+// the contents of that file along with a Provider which re-reads it.
+// Setting both k and k_FILE is an error.
+func getenvSecret(k string) (string, credential.Provider, error) {
+	v := env.Getenv(k)
+	path := env.Getenv(k + "_FILE")
+	if len(path) <= 0 {
+		return v, nil, nil
+	}
 	if len(v) > 0 {
-		return true
+		return "", nil, fmt.Errorf("%s and %s_FILE are both set; use only one", k, k)
+	}
+	provider := credential.File(path)
+	v, err := provider.Credential()
+	if err != nil {
+		return "", nil, fmt.Errorf("%s_FILE: %v", k, err)
+	}
+	return v, provider, nil
+}
+
+func getenvDefault(k, defaultValue string) string {
+	v := env.Getenv(k)
+	if len(v) > 0 {
+		return v
+	}
+	return defaultValue
+}
+
+// loadConfig reads the config file at path, interpolating variables from env.
+func loadConfig(path string) (*fromfile.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := fromfile.Parse(data, env.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
-	return false
+	return cfg, nil
 }
 
 func initEnv() {
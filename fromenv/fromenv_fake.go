@@ -6,10 +6,23 @@ type fakeEnv struct {
 	values map[string]string
 }
 
+func (env fakeEnv) Environ() []string {
+	var environ []string
+	for k, v := range env.values {
+		environ = append(environ, k+"="+v)
+	}
+	return environ
+}
+
 func (env fakeEnv) Getenv(k string) string {
 	return env.values[k]
 }
 
+func (env fakeEnv) LookupEnv(k string) (string, bool) {
+	v, ok := env.values[k]
+	return v, ok
+}
+
 func (env fakeEnv) Setenv(k string, v string) {
 	env.values[k] = v
 }
@@ -0,0 +1,556 @@
+package fromenv
+
+import (
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/proxy"
+	"github.com/djschaap/logevent/internal/sendhec"
+	"github.com/djschaap/logevent/internal/tlsconfig"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity is the importance of a Finding.
+type Severity int
+
+// Severity values, from least to most important.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "Severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Finding is one diagnostic produced by Validate.
+type Finding struct {
+	Message  string
+	Severity Severity
+	Variable string // environment variable concerned; empty if none
+}
+
+func (f Finding) String() string {
+	if f.Variable == "" {
+		return fmt.Sprintf("%-7s %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%-7s %s: %s", f.Severity, f.Variable, f.Message)
+}
+
+// Report is the result of Validate.
+type Report struct {
+	Findings []Finding
+	Sender   string // selected SENDER_PACKAGE, or senddump when unset; with SENDER_CONFIG, the output's type
+}
+
+// HasErrors returns true when any Finding has SeverityError.
+func (r Report) HasErrors() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) String() string {
+	lines := []string{"sender: " + r.Sender}
+	for _, f := range r.Findings {
+		lines = append(lines, f.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (r *Report) add(severity Severity, variable, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{
+		Message:  fmt.Sprintf(format, args...),
+		Severity: severity,
+		Variable: variable,
+	})
+}
+
+// knownVariables lists the variables read by this package, by prefix.
+// Variables with one of these prefixes which are not listed are reported by Validate.
+var knownVariables = map[string][]string{
+	"AMQP_":   {"AMQP_CONFIRM_TIMEOUT", "AMQP_EXCHANGE", "AMQP_HOST", "AMQP_PASSWORD", "AMQP_PASSWORD_FILE", "AMQP_PORT", "AMQP_PROTOCOL", "AMQP_ROUTING_KEY", "AMQP_TTL", "AMQP_URL", "AMQP_URL_FILE", "AMQP_USERNAME", "AMQP_VHOST"},
+	"HEC_":    {"HEC_ACK_RESENDS", "HEC_ACK_TIMEOUT", "HEC_BALANCE", "HEC_CONNECT_TIMEOUT", "HEC_HTTP2", "HEC_IDLE_TIMEOUT", "HEC_INSECURE", "HEC_KEEP_ALIVE", "HEC_MAX_IDLE_CONNS", "HEC_RAW", "HEC_REQUEST_TIMEOUT", "HEC_RESPONSE_TIMEOUT", "HEC_TLS_TIMEOUT", "HEC_TOKEN", "HEC_TOKEN_FILE", "HEC_URL"},
+	"PROXY_":  {"PROXY_URL", "PROXY_URL_FILE"},
+	"SENDER_": {"SENDER_CONFIG", "SENDER_OUTPUT", "SENDER_PACKAGE", "SENDER_TRACE", "SENDER_URL", "SENDER_URL_FILE"},
+	"TLS_":    tlsVariables,
+}
+
+var tlsVariables = []string{"TLS_CA_FILE", "TLS_CERT_FILE", "TLS_CIPHER_SUITES", "TLS_KEY_FILE", "TLS_MIN_VERSION", "TLS_SERVER_NAME"}
+
+var senderPackages = []string{"sendamqp", "senddump", "sendhec", "sendsns"}
+
+var snsTopicArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:sns:([a-z0-9-]+):\d{12}:[A-Za-z0-9_-]{1,256}(\.fifo)?$`)
+
+// Validate checks the environment settings used by GetMessageSenderFromEnv for the selected sender.
+// When probe is true and no errors were found, Validate also checks that the destination is reachable,
+// without sending a LogEvent.
+func Validate(probe bool) Report {
+	initEnv()
+	var r Report
+	r.Sender = env.Getenv("SENDER_PACKAGE")
+	if r.Sender == "" {
+		r.Sender = "senddump"
+	}
+	validateUnknownVariables(&r)
+	trace, err := getenvBool("SENDER_TRACE")
+	if err != nil {
+		r.add(SeverityError, "SENDER_TRACE", "%v", err)
+	}
+
+	if env.Getenv("SENDER_CONFIG") != "" {
+		validateConfig(&r)
+	} else if env.Getenv("SENDER_URL") != "" || env.Getenv("SENDER_URL_FILE") != "" {
+		validateSenderURL(&r)
+	} else {
+		switch r.Sender {
+		case "sendamqp":
+			validateAmqp(&r)
+		case "senddump":
+			if err == nil && !trace {
+				r.add(SeverityWarning, "SENDER_TRACE", "senddump writes nothing unless SENDER_TRACE is true")
+			}
+		case "sendhec":
+			validateHec(&r)
+		case "sendsns":
+			validateSns(&r)
+		default:
+			r.add(SeverityError, "SENDER_PACKAGE", "%q is not valid; valid packages are %s%s",
+				r.Sender, strings.Join(senderPackages, ", "), didYouMean(r.Sender, senderPackages))
+			return r
+		}
+	}
+	validateTLS(&r)
+	validateProxy(&r)
+	if r.HasErrors() {
+		return r
+	}
+
+	sender, err := GetMessageSenderFromEnv()
+	if err != nil {
+		r.add(SeverityError, "", "%v", err)
+		return r
+	}
+	if probe {
+		err = probeSender(sender)
+		if err != nil {
+			r.add(SeverityError, "", "probe failed: %v", err)
+		} else {
+			r.add(SeverityInfo, "", "probe succeeded")
+		}
+	}
+	return r
+}
+
+func probeSender(sender logevent.MessageSender) error {
+	if prober, ok := sender.(logevent.Prober); ok {
+		return prober.Probe()
+	}
+	err := sender.OpenSvc()
+	if err != nil {
+		return err
+	}
+	return sender.CloseSvc()
+}
+
+func validateAmqp(r *Report) {
+	for _, k := range []string{"AMQP_PASSWORD", "AMQP_URL"} {
+		if _, _, err := getenvSecret(k); err != nil {
+			r.add(SeverityError, k+"_FILE", "%v", err)
+			return
+		}
+	}
+	hasURL := env.Getenv("AMQP_URL") != "" || env.Getenv("AMQP_URL_FILE") != ""
+	if hasURL {
+		for _, k := range []string{"AMQP_HOST", "AMQP_PASSWORD", "AMQP_PASSWORD_FILE", "AMQP_PORT", "AMQP_PROTOCOL", "AMQP_USERNAME", "AMQP_VHOST"} {
+			if env.Getenv(k) != "" {
+				r.add(SeverityWarning, k, "ignored because AMQP_URL is set")
+			}
+		}
+	} else {
+		if port := env.Getenv("AMQP_PORT"); port != "" {
+			n, err := strconv.Atoi(port)
+			if err != nil || n < 1 || n > 65535 {
+				r.add(SeverityError, "AMQP_PORT", "%q is not a port number", port)
+			}
+		}
+	}
+
+	urlVariable := "AMQP_URL"
+	if env.Getenv("AMQP_URL_FILE") != "" {
+		urlVariable = "AMQP_URL_FILE"
+	} else if !hasURL {
+		urlVariable = "AMQP_HOST"
+	}
+	amqpURL, _, err := amqpUrlFromEnv()
+	if err != nil {
+		r.add(SeverityError, urlVariable, "%v", err)
+		return
+	}
+	u, err := url.Parse(amqpURL)
+	if err != nil {
+		r.add(SeverityError, urlVariable, "connection URL is not valid: %v", err)
+	} else {
+		if u.Scheme != "amqp" && u.Scheme != "amqps" {
+			variable := "AMQP_PROTOCOL"
+			if hasURL {
+				variable = urlVariable
+			}
+			r.add(SeverityError, variable, "scheme %q is not amqp or amqps", u.Scheme)
+		}
+		if u.Hostname() == "" {
+			r.add(SeverityError, urlVariable, "no host is set")
+		}
+		if u.User == nil || u.User.Username() == "" {
+			r.add(SeverityWarning, urlVariable, "no username is set")
+		}
+	}
+
+	if env.Getenv("AMQP_EXCHANGE") == "" {
+		r.add(SeverityWarning, "AMQP_EXCHANGE", "not set; messages are published to the default exchange")
+	}
+	if env.Getenv("AMQP_ROUTING_KEY") == "" {
+		r.add(SeverityWarning, "AMQP_ROUTING_KEY", "not set; messages are published with an empty routing key")
+	}
+	if ttl := env.Getenv("AMQP_TTL"); ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil || n < 0 {
+			r.add(SeverityError, "AMQP_TTL", "%q is not a whole number of seconds", ttl)
+		}
+	}
+	confirmTimeout, err := parseTimeout("AMQP_CONFIRM_TIMEOUT", env.Getenv("AMQP_CONFIRM_TIMEOUT"))
+	if err != nil {
+		r.add(SeverityError, "AMQP_CONFIRM_TIMEOUT", "%v", err)
+	} else if confirmTimeout > 0 {
+		r.add(SeverityInfo, "AMQP_CONFIRM_TIMEOUT", "publisher confirm timeout %v", confirmTimeout)
+	}
+}
+
+func validateConfig(r *Report) {
+	configPath := env.Getenv("SENDER_CONFIG")
+	r.Sender = "unknown"
+	if env.Getenv("SENDER_PACKAGE") != "" {
+		r.add(SeverityWarning, "SENDER_PACKAGE", "ignored because SENDER_CONFIG is set")
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		r.add(SeverityError, "SENDER_CONFIG", "%v", err)
+		return
+	}
+	name, output, err := cfg.Lookup(env.Getenv("SENDER_OUTPUT"))
+	if err != nil {
+		r.add(SeverityError, "SENDER_OUTPUT", "%v", err)
+		return
+	}
+	r.Sender = output.Type
+	r.add(SeverityInfo, "SENDER_CONFIG", "using output %s from %s", name, configPath)
+}
+
+func validateHec(r *Report) {
+	token, _, err := getenvSecret("HEC_TOKEN")
+	if err != nil {
+		r.add(SeverityError, "HEC_TOKEN_FILE", "%v", err)
+	} else if token == "" {
+		r.add(SeverityError, "HEC_TOKEN", "required by sendhec (or HEC_TOKEN_FILE)")
+	}
+	hecURL := env.Getenv("HEC_URL")
+	if hecURL == "" {
+		r.add(SeverityError, "HEC_URL", "required by sendhec")
+	} else {
+		for _, u := range splitList(hecURL) {
+			validateHecURL(r, "HEC_URL", u)
+		}
+	}
+	balance, err := sendhec.ParseBalance(env.Getenv("HEC_BALANCE"))
+	if err != nil {
+		r.add(SeverityError, "HEC_BALANCE", "%v", err)
+	} else if n := len(splitList(hecURL)); n > 1 {
+		r.add(SeverityInfo, "HEC_URL", "%d URLs, balanced %s, with health checks", n, balance)
+	} else if env.Getenv("HEC_BALANCE") != "" {
+		r.add(SeverityWarning, "HEC_BALANCE", "ignored because HEC_URL has only one URL")
+	}
+	insecure, err := getenvBool("HEC_INSECURE")
+	if err != nil {
+		r.add(SeverityError, "HEC_INSECURE", "%v", err)
+	} else if insecure {
+		r.add(SeverityWarning, "HEC_INSECURE", "TLS certificate verification is disabled")
+	}
+	_, err = getenvBool("HEC_RAW")
+	if err != nil {
+		r.add(SeverityError, "HEC_RAW", "%v", err)
+	}
+	ackTimeout, ackResends, err := parseAck(
+		"HEC_ACK_TIMEOUT", env.Getenv("HEC_ACK_TIMEOUT"),
+		"HEC_ACK_RESENDS", env.Getenv("HEC_ACK_RESENDS"))
+	if err != nil {
+		variable := "HEC_ACK_TIMEOUT"
+		if strings.HasPrefix(err.Error(), "HEC_ACK_RESENDS") {
+			variable = "HEC_ACK_RESENDS"
+		}
+		r.add(SeverityError, variable, "%v", err)
+	} else if ackTimeout > 0 {
+		r.add(SeverityInfo, "HEC_ACK_TIMEOUT", "indexer acknowledgment timeout %v with %d re-send(s); acknowledgment must be enabled for the token", ackTimeout, ackResends)
+	} else if env.Getenv("HEC_ACK_RESENDS") != "" {
+		r.add(SeverityWarning, "HEC_ACK_RESENDS", "ignored because HEC_ACK_TIMEOUT is not set")
+	}
+	for _, option := range httpOptions {
+		variable := "HEC_" + strings.ToUpper(option)
+		_, err := parseHTTPSettings(func(o string) (string, string) {
+			if o != option {
+				return "", ""
+			}
+			return variable, env.Getenv(variable)
+		})
+		if err != nil {
+			r.add(SeverityError, variable, "%v", err)
+		}
+	}
+	for _, variable := range []string{"HEC_REQUEST_TIMEOUT", "HEC_RESPONSE_TIMEOUT"} {
+		if d, err := time.ParseDuration(env.Getenv(variable)); err == nil && d == 0 {
+			r.add(SeverityWarning, variable, "0 is no limit; a stalled connection blocks sends")
+		}
+	}
+}
+
+func validateHecURL(r *Report, variable, hecURL string) {
+	u, err := url.Parse(hecURL)
+	if err != nil {
+		r.add(SeverityError, variable, "not a valid URL: %v", err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		r.add(SeverityError, variable, "scheme %q is not http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		r.add(SeverityError, variable, "no host is set")
+	}
+	if u.Path != "" && u.Path != "/" {
+		r.add(SeverityWarning, variable, "path %s is unexpected; /services/collector is appended to the URL", u.Path)
+	}
+}
+
+func validateSenderURL(r *Report) {
+	variable := "SENDER_URL"
+	if env.Getenv("SENDER_URL_FILE") != "" {
+		variable = "SENDER_URL_FILE"
+	}
+	r.Sender = "unknown"
+	if env.Getenv("SENDER_PACKAGE") != "" {
+		r.add(SeverityWarning, "SENDER_PACKAGE", "ignored because %s is set", variable)
+	}
+	senderURL, _, err := getenvSecret("SENDER_URL")
+	if err != nil {
+		r.add(SeverityError, variable, "%v", err)
+		return
+	}
+	d, err := ParseSenderURL(senderURL)
+	if err != nil {
+		r.add(SeverityError, variable, "%v", err)
+		return
+	}
+	r.Sender = d.Package
+	r.add(SeverityInfo, variable, "using %s", d)
+	switch d.Package {
+	case "sendamqp":
+		if d.Exchange == "" {
+			r.add(SeverityWarning, variable, "no exchange option; messages are published to the default exchange")
+		}
+		if d.RoutingKey == "" {
+			r.add(SeverityWarning, variable, "no routing_key option; messages are published with an empty routing key")
+		}
+	case "sendhec":
+		for _, u := range strings.Split(d.URL, ",") {
+			validateHecURL(r, variable, u)
+		}
+		if d.Insecure {
+			r.add(SeverityWarning, variable, "TLS certificate verification is disabled")
+		}
+	case "sendsns":
+		validateSnsTopic(r, variable, d.Topic)
+	}
+}
+
+func validateSns(r *Report) {
+	topic := env.Getenv("AWS_SNS_TOPIC")
+	if topic == "" {
+		r.add(SeverityError, "AWS_SNS_TOPIC", "required by sendsns")
+		return
+	}
+	validateSnsTopic(r, "AWS_SNS_TOPIC", topic)
+}
+
+func validateSnsTopic(r *Report, variable, topic string) {
+	m := snsTopicArnPattern.FindStringSubmatch(topic)
+	if m == nil {
+		r.add(SeverityError, variable, "%q is not an SNS topic ARN (arn:aws:sns:REGION:ACCOUNT:NAME)", topic)
+		return
+	}
+	region := env.Getenv("AWS_REGION")
+	if region == "" {
+		region = env.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		r.add(SeverityInfo, "AWS_REGION", "not set; the region must come from shared AWS configuration and match the topic (%s)", m[1])
+	} else if region != m[1] {
+		r.add(SeverityError, "AWS_REGION", "%s does not match the topic's region %s", region, m[1])
+	}
+}
+
+// ignoredConnectionVariables warns about each of the TLS_ or PROXY_ variables set,
+// and returns true, when they are not used: with SENDER_CONFIG, or by the selected sender.
+func ignoredConnectionVariables(r *Report, set []string) bool {
+	reason := "not used by " + r.Sender
+	if env.Getenv("SENDER_CONFIG") != "" {
+		reason = "SENDER_CONFIG is set"
+	} else if r.Sender == "sendamqp" || r.Sender == "sendhec" {
+		return false
+	}
+	for _, k := range set {
+		r.add(SeverityWarning, k, "ignored because %s", reason)
+	}
+	return true
+}
+
+// validateProxy checks PROXY_URL, which applies only to sendamqp and sendhec.
+func validateProxy(r *Report) {
+	variable := "PROXY_URL"
+	if env.Getenv("PROXY_URL_FILE") != "" {
+		variable = "PROXY_URL_FILE"
+	}
+	if env.Getenv(variable) == "" || ignoredConnectionVariables(r, []string{variable}) {
+		return
+	}
+	proxyURL, _, err := getenvSecret("PROXY_URL")
+	if err != nil {
+		r.add(SeverityError, variable, "%v", err)
+		return
+	}
+	u, err := proxy.Parse(proxyURL)
+	if err != nil {
+		r.add(SeverityError, variable, "%v", err)
+		return
+	}
+	r.add(SeverityInfo, variable, "connecting through proxy %s", proxy.String(u))
+}
+
+// validateTLS checks the TLS_ variables, which apply only to sendamqp and sendhec.
+func validateTLS(r *Report) {
+	var set []string
+	for _, k := range tlsVariables {
+		if env.Getenv(k) != "" {
+			set = append(set, k)
+		}
+	}
+	if len(set) == 0 {
+		return
+	}
+	if ignoredConnectionVariables(r, set) {
+		return
+	}
+	s := tlsSettingsFromEnv()
+	for _, check := range []struct {
+		variable string
+		settings tlsconfig.Settings
+	}{
+		{"TLS_CA_FILE", tlsconfig.Settings{CAFile: s.CAFile}},
+		{"TLS_CERT_FILE", tlsconfig.Settings{CertFile: s.CertFile, KeyFile: s.KeyFile}},
+		{"TLS_CIPHER_SUITES", tlsconfig.Settings{CipherSuites: s.CipherSuites}},
+		{"TLS_MIN_VERSION", tlsconfig.Settings{MinVersion: s.MinVersion}},
+	} {
+		if _, err := check.settings.Config(); err != nil {
+			r.add(SeverityError, check.variable, "%v", err)
+		}
+	}
+}
+
+func validateUnknownVariables(r *Report) {
+	var unknown []string
+	for _, kv := range env.Environ() {
+		k := strings.SplitN(kv, "=", 2)[0]
+		for prefix, known := range knownVariables {
+			if strings.HasPrefix(k, prefix) && !contains(known, k) {
+				unknown = append(unknown, k)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		var candidates []string
+		for _, known := range knownVariables {
+			candidates = append(candidates, known...)
+		}
+		r.add(SeverityWarning, k, "unknown variable%s", didYouMean(k, candidates))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// didYouMean returns a suggestion for the candidate closest to s, or "" if none is close.
+func didYouMean(s string, candidates []string) string {
+	best := ""
+	bestDistance := len(s)/3 + 1
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(s), strings.ToLower(c))
+		if d < bestDistance || (d == bestDistance && best != "" && c < best) {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return "; did you mean " + best + "?"
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
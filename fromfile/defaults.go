@@ -0,0 +1,91 @@
+package fromfile
+
+import (
+	"errors"
+	"github.com/djschaap/logevent"
+)
+
+// defaultsSender applies an output's Defaults to each LogEvent before passing it on.
+type defaultsSender struct {
+	defaults Defaults
+	sender   logevent.MessageSender
+}
+
+func (s *defaultsSender) CloseSvc() error {
+	return s.sender.CloseSvc()
+}
+
+func (s *defaultsSender) OpenSvc() error {
+	return s.sender.OpenSvc()
+}
+
+func (s *defaultsSender) Probe() error {
+	prober, ok := s.sender.(logevent.Prober)
+	if !ok {
+		return errors.New("wrapped sender does not support Probe")
+	}
+	return prober.Probe()
+}
+
+func (s *defaultsSender) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	renderer, ok := s.sender.(logevent.Renderer)
+	if !ok {
+		return logevent.Payload{}, errors.New("wrapped sender does not support Render")
+	}
+	return renderer.Render(s.apply(logEvent))
+}
+
+func (s *defaultsSender) SendBatch(logEvents []logevent.LogEvent) error {
+	applied := make([]logevent.LogEvent, len(logEvents))
+	for i, logEvent := range logEvents {
+		applied[i] = s.apply(logEvent)
+	}
+	if batchSender, ok := s.sender.(logevent.BatchSender); ok {
+		return batchSender.SendBatch(applied)
+	}
+	for _, logEvent := range applied {
+		err := s.sender.SendMessage(logEvent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *defaultsSender) SendMessage(logEvent logevent.LogEvent) error {
+	return s.sender.SendMessage(s.apply(logEvent))
+}
+
+func (s *defaultsSender) SetTrace(v bool) {
+	s.sender.SetTrace(v)
+}
+
+func (s *defaultsSender) apply(logEvent logevent.LogEvent) logevent.LogEvent {
+	d := s.defaults
+	setDefault(&logEvent.Attributes.CustomerCode, d.CustomerCode)
+	setDefault(&logEvent.Attributes.Host, d.Host)
+	setDefault(&logEvent.Attributes.Source, d.Source)
+	setDefault(&logEvent.Attributes.SourceEnvironment, d.SourceEnvironment)
+	setDefault(&logEvent.Attributes.Sourcetype, d.Sourcetype)
+	setDefault(&logEvent.Content.Host, d.Host)
+	setDefault(&logEvent.Content.Index, d.Index)
+	setDefault(&logEvent.Content.Source, d.Source)
+	setDefault(&logEvent.Content.Sourcetype, d.Sourcetype)
+	if len(d.Fields) > 0 {
+		fields := make(map[string]interface{}, len(d.Fields)+len(logEvent.Content.Fields))
+		for k, v := range d.Fields {
+			fields[k] = v
+		}
+		for k, v := range logEvent.Content.Fields {
+			fields[k] = v
+		}
+		logEvent.Content.Fields = fields
+	}
+	return logEvent
+}
+
+func setDefault(field *string, v string) {
+	if *field == "" {
+		*field = v
+	}
+}
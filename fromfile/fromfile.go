@@ -0,0 +1,564 @@
+package fromfile
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/proxy"
+	"github.com/djschaap/logevent/internal/sendamqp"
+	"github.com/djschaap/logevent/internal/sendbuffer"
+	"github.com/djschaap/logevent/internal/senddump"
+	"github.com/djschaap/logevent/internal/sendhec"
+	"github.com/djschaap/logevent/internal/sendretry"
+	"github.com/djschaap/logevent/internal/sendsns"
+	"github.com/djschaap/logevent/internal/tlsconfig"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is a set of named outputs, read from a JSON file by Load.
+type Config struct {
+	Default string            `json:"default,omitempty"` // output used when no name is given; optional with a single output
+	Outputs map[string]Output `json:"outputs"`
+
+	unresolved map[string]error // outputs which refer to unset variables
+}
+
+// Output defines one destination: its sender type, the settings for that type,
+// defaults applied to each LogEvent, and optional retry and buffer wrappers.
+type Output struct {
+	Type     string              `json:"type"` // sendamqp, senddump, sendhec or sendsns
+	AMQP     *AMQPSettings       `json:"amqp,omitempty"`
+	Buffer   *BufferSettings     `json:"buffer,omitempty"`
+	Defaults *Defaults           `json:"defaults,omitempty"`
+	HEC      *HECSettings        `json:"hec,omitempty"`
+	Proxy    *ProxySettings      `json:"proxy,omitempty"` // sendamqp and sendhec
+	Retry    *RetrySettings      `json:"retry,omitempty"`
+	SNS      *SNSSettings        `json:"sns,omitempty"`
+	TLS      *tlsconfig.Settings `json:"tls,omitempty"` // sendamqp (amqps:// URLs) and sendhec
+	Trace    bool                `json:"trace,omitempty"`
+}
+
+// AMQPSettings configures a sendamqp output.
+// With confirm_timeout set, each send waits for the broker to confirm its messages.
+type AMQPSettings struct {
+	ConfirmTimeout Duration    `json:"confirm_timeout,omitempty"`
+	Exchange       string      `json:"exchange,omitempty"`
+	RoutingKey     string      `json:"routing_key,omitempty"`
+	TTL            json.Number `json:"ttl,omitempty"` // seconds
+	URL            string      `json:"url"`
+}
+
+// BufferSettings wraps an output with sendbuffer, so LogEvents are sent in the background.
+type BufferSettings struct {
+	BatchSize     int      `json:"batch_size,omitempty"`
+	FlushInterval Duration `json:"flush_interval,omitempty"`
+	Size          int      `json:"size"`
+}
+
+// Defaults are copied into each LogEvent field which is empty.
+// Host, Source and Sourcetype apply to both Attributes and Content.
+// Fields are merged, with the LogEvent's own fields taking precedence.
+type Defaults struct {
+	CustomerCode      string                 `json:"customer_code,omitempty"`
+	Fields            map[string]interface{} `json:"fields,omitempty"`
+	Host              string                 `json:"host,omitempty"`
+	Index             string                 `json:"index,omitempty"`
+	Source            string                 `json:"source,omitempty"`
+	SourceEnvironment string                 `json:"source_environment,omitempty"`
+	Sourcetype        string                 `json:"sourcetype,omitempty"`
+}
+
+// HECSettings configures a sendhec output.
+// With ack_timeout set, each send waits for indexer acknowledgment,
+// re-sending unacknowledged events up to ack_resends times (default 1).
+// Either url or urls is required; with urls, requests are distributed
+// among them by balance (round-robin or least-latency).
+type HECSettings struct {
+	AckResends      *int      `json:"ack_resends,omitempty"`
+	AckTimeout      Duration  `json:"ack_timeout,omitempty"`
+	Balance         string    `json:"balance,omitempty"`
+	ConnectTimeout  *Duration `json:"connect_timeout,omitempty"`
+	HTTP2           *bool     `json:"http2,omitempty"`
+	IdleTimeout     *Duration `json:"idle_timeout,omitempty"`
+	Insecure        bool      `json:"insecure,omitempty"`
+	KeepAlive       *bool     `json:"keep_alive,omitempty"`
+	MaxIdleConns    *int      `json:"max_idle_conns,omitempty"`
+	Raw             bool      `json:"raw,omitempty"`
+	RequestTimeout  *Duration `json:"request_timeout,omitempty"`
+	ResponseTimeout *Duration `json:"response_timeout,omitempty"`
+	TLSTimeout      *Duration `json:"tls_timeout,omitempty"`
+	Token           string    `json:"token"`
+	URL             string    `json:"url,omitempty"`
+	URLs            []string  `json:"urls,omitempty"`
+}
+
+// httpSettings returns the connection settings of h, with the defaults for those not set.
+func (h HECSettings) httpSettings() sendhec.HTTPSettings {
+	s := sendhec.DefaultHTTPSettings()
+	for _, d := range []struct {
+		setting *Duration
+		target  *time.Duration
+	}{
+		{h.ConnectTimeout, &s.ConnectTimeout},
+		{h.IdleTimeout, &s.IdleTimeout},
+		{h.RequestTimeout, &s.RequestTimeout},
+		{h.ResponseTimeout, &s.ResponseTimeout},
+		{h.TLSTimeout, &s.TLSTimeout},
+	} {
+		if d.setting != nil {
+			*d.target = time.Duration(*d.setting)
+		}
+	}
+	if h.HTTP2 != nil {
+		s.DisableHTTP2 = !*h.HTTP2
+	}
+	if h.KeepAlive != nil {
+		s.DisableKeepAlive = !*h.KeepAlive
+	}
+	if h.MaxIdleConns != nil {
+		s.MaxIdleConns = *h.MaxIdleConns
+	}
+	return s
+}
+
+// ProxySettings sets the proxy of a sendamqp or sendhec output, in place of
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+type ProxySettings struct {
+	NoProxy string `json:"no_proxy,omitempty"` // comma-separated, as NO_PROXY
+	URL     string `json:"url"`                // http, https, socks5 or socks5h, with optional credentials
+}
+
+// RetrySettings wraps an output with sendretry, so failed sends are retried.
+type RetrySettings struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    Duration `json:"backoff,omitempty"`
+	MaxBackoff Duration `json:"max_backoff,omitempty"`
+}
+
+// SNSSettings configures a sendsns output.
+type SNSSettings struct {
+	Topic string `json:"topic"`
+}
+
+// Duration is a time.Duration read from JSON as a string such as "1.5s",
+// or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return fmt.Errorf("duration %s is not a string or number", b)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Load reads a Config from the JSON file at path, replacing ${VAR}
+// references in string values with environment variables.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse reads a Config from JSON data.
+// In string values, ${VAR} is replaced by the value lookup returns for VAR,
+// ${VAR:-DEFAULT} by DEFAULT when VAR is unset or empty, and $$ by a literal $.
+// An output which refers to an unset variable, without a default, can still be
+// listed, but Lookup and NewSender return an error for it.
+func Parse(data []byte, lookup func(string) (string, bool)) (*Config, error) {
+	var root map[string]interface{}
+	err := strictUnmarshal(data, &root, false)
+	if err != nil {
+		return nil, err
+	}
+	unresolved := make(map[string]error)
+	for k, v := range root {
+		if outputs, ok := v.(map[string]interface{}); ok && k == "outputs" {
+			for name, output := range outputs {
+				outputs[name], err = interpolateTree(output, lookup)
+				if err != nil {
+					unresolved[name] = err
+				}
+			}
+			continue
+		}
+		root[k], err = interpolateTree(v, lookup)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err = json.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	err = strictUnmarshal(data, &cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	cfg.unresolved = unresolved
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Lookup returns the output called name, or the default output when name is empty,
+// along with the name of the output returned.
+func (cfg *Config) Lookup(name string) (string, Output, error) {
+	if name == "" {
+		name = cfg.Default
+	}
+	if name == "" {
+		if len(cfg.Outputs) != 1 {
+			return "", Output{}, errors.New("no output named and no default output set")
+		}
+		for k := range cfg.Outputs {
+			name = k
+		}
+	}
+	output, ok := cfg.Outputs[name]
+	if !ok {
+		return "", Output{}, fmt.Errorf("no output named %q; outputs are %s", name, strings.Join(cfg.Names(), ", "))
+	}
+	if err := cfg.unresolved[name]; err != nil {
+		return "", Output{}, fmt.Errorf("output %s: %v", name, err)
+	}
+	return name, output, nil
+}
+
+// Names returns the names of all outputs, sorted.
+func (cfg *Config) Names() []string {
+	var names []string
+	for k := range cfg.Outputs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSender returns a MessageSender for the output called name,
+// or for the default output when name is empty.
+func (cfg *Config) NewSender(name string) (logevent.MessageSender, error) {
+	name, output, err := cfg.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var sender logevent.MessageSender
+	switch output.Type {
+	case "sendamqp":
+		amqpSender := sendamqp.New(output.AMQP.URL, output.AMQP.Exchange, output.AMQP.RoutingKey, output.AMQP.TTL.String())
+		amqpSender.SetConfirm(time.Duration(output.AMQP.ConfirmTimeout))
+		sender = amqpSender
+	case "senddump":
+		sender = senddump.New()
+	case "sendhec":
+		hecURLs := output.HEC.URLs
+		if output.HEC.URL != "" {
+			hecURLs = []string{output.HEC.URL}
+		}
+		hecSender := sendhec.New(hecURLs[0], output.HEC.Token)
+		if len(hecURLs) > 1 {
+			hecSender.SetURLs(hecURLs)
+		}
+		balance, _ := sendhec.ParseBalance(output.HEC.Balance)
+		hecSender.SetBalance(balance)
+		if output.HEC.AckTimeout > 0 {
+			resends := 1
+			if output.HEC.AckResends != nil {
+				resends = *output.HEC.AckResends
+			}
+			hecSender.SetAck(time.Duration(output.HEC.AckTimeout), resends)
+		}
+		hecSender.SetHecInsecure(output.HEC.Insecure)
+		hecSender.SetHTTP(output.HEC.httpSettings())
+		hecSender.SetRaw(output.HEC.Raw)
+		sender = hecSender
+	case "sendsns":
+		sender = sendsns.New(output.SNS.Topic)
+	default:
+		return nil, fmt.Errorf("output %s: type %q is not valid", name, output.Type)
+	}
+	if output.TLS != nil {
+		tlsConfig, err := output.TLS.Config()
+		if err != nil {
+			return nil, fmt.Errorf("output %s: tls %v", name, err)
+		}
+		if c, ok := sender.(interface{ SetTLSConfig(*tls.Config) }); ok && tlsConfig != nil {
+			c.SetTLSConfig(tlsConfig)
+		}
+	}
+	if output.Proxy != nil {
+		proxyURL, _ := proxy.Parse(output.Proxy.URL)
+		if c, ok := sender.(interface{ SetProxy(proxy.Func) }); ok {
+			c.SetProxy(proxy.Fixed(proxyURL, output.Proxy.NoProxy))
+		}
+	}
+
+	if output.Defaults != nil {
+		sender = &defaultsSender{defaults: *output.Defaults, sender: sender}
+	}
+	if output.Retry != nil {
+		retrySender := sendretry.New(sender, output.Retry.Attempts)
+		if output.Retry.Backoff > 0 {
+			maxBackoff := time.Duration(output.Retry.MaxBackoff)
+			if maxBackoff == 0 {
+				maxBackoff = 30 * time.Second
+			}
+			retrySender.SetBackoff(time.Duration(output.Retry.Backoff), maxBackoff)
+		}
+		sender = retrySender
+	}
+	if output.Buffer != nil {
+		bufferSender := sendbuffer.New(sender, output.Buffer.Size)
+		if output.Buffer.BatchSize > 0 || output.Buffer.FlushInterval > 0 {
+			batchSize := output.Buffer.BatchSize
+			if batchSize == 0 {
+				batchSize = 100
+			}
+			flushInterval := time.Duration(output.Buffer.FlushInterval)
+			if flushInterval == 0 {
+				flushInterval = time.Second
+			}
+			bufferSender.SetBatch(batchSize, flushInterval)
+		}
+		sender = bufferSender
+	}
+	if output.Trace {
+		sender.SetTrace(true)
+	}
+	return sender, nil
+}
+
+func (cfg *Config) validate() error {
+	if len(cfg.Outputs) == 0 {
+		return errors.New("no outputs are defined")
+	}
+	if cfg.Default != "" {
+		if _, ok := cfg.Outputs[cfg.Default]; !ok {
+			return fmt.Errorf("default output %q is not defined", cfg.Default)
+		}
+	}
+	for _, name := range cfg.Names() {
+		if cfg.unresolved[name] != nil {
+			continue
+		}
+		err := cfg.Outputs[name].validate()
+		if err != nil {
+			return fmt.Errorf("output %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (output Output) validate() error {
+	settings := map[string]bool{
+		"amqp": output.AMQP != nil,
+		"hec":  output.HEC != nil,
+		"sns":  output.SNS != nil,
+	}
+	var required string
+	switch output.Type {
+	case "sendamqp":
+		required = "amqp"
+	case "senddump":
+	case "sendhec":
+		required = "hec"
+	case "sendsns":
+		required = "sns"
+	default:
+		return fmt.Errorf("type %q is not valid; valid types are sendamqp, senddump, sendhec, sendsns", output.Type)
+	}
+	for _, k := range []string{"amqp", "hec", "sns"} {
+		if k == required && !settings[k] {
+			return fmt.Errorf("%s requires %s settings", output.Type, k)
+		}
+		if k != required && settings[k] {
+			return fmt.Errorf("%s settings are not used by %s", k, output.Type)
+		}
+	}
+
+	switch output.Type {
+	case "sendamqp":
+		if output.AMQP.URL == "" {
+			return errors.New("amqp.url is required")
+		}
+		if ttl := output.AMQP.TTL.String(); ttl != "" {
+			n, err := strconv.Atoi(ttl)
+			if err != nil || n < 0 {
+				return fmt.Errorf("amqp.ttl %s is not a whole number of seconds", ttl)
+			}
+		}
+		if output.AMQP.ConfirmTimeout < 0 {
+			return errors.New("amqp.confirm_timeout must not be negative")
+		}
+	case "sendhec":
+		if (output.HEC.URL == "") == (len(output.HEC.URLs) == 0) {
+			return errors.New("hec.url or hec.urls is required, but not both")
+		}
+		for _, u := range output.HEC.URLs {
+			if u == "" {
+				return errors.New("hec.urls must not contain an empty URL")
+			}
+		}
+		_, err := sendhec.ParseBalance(output.HEC.Balance)
+		if err != nil {
+			return fmt.Errorf("hec.balance %v", err)
+		}
+		if output.HEC.Token == "" {
+			return errors.New("hec.token is required")
+		}
+		if output.HEC.AckTimeout < 0 {
+			return errors.New("hec.ack_timeout must not be negative")
+		}
+		if output.HEC.AckResends != nil && *output.HEC.AckResends < 0 {
+			return errors.New("hec.ack_resends must not be negative")
+		}
+		for _, d := range []struct {
+			name    string
+			setting *Duration
+		}{
+			{"connect_timeout", output.HEC.ConnectTimeout},
+			{"idle_timeout", output.HEC.IdleTimeout},
+			{"request_timeout", output.HEC.RequestTimeout},
+			{"response_timeout", output.HEC.ResponseTimeout},
+			{"tls_timeout", output.HEC.TLSTimeout},
+		} {
+			if d.setting != nil && *d.setting < 0 {
+				return fmt.Errorf("hec.%s must not be negative", d.name)
+			}
+		}
+		if output.HEC.MaxIdleConns != nil && *output.HEC.MaxIdleConns < 0 {
+			return errors.New("hec.max_idle_conns must not be negative")
+		}
+	case "sendsns":
+		if output.SNS.Topic == "" {
+			return errors.New("sns.topic is required")
+		}
+	}
+	if output.TLS != nil {
+		if output.Type != "sendamqp" && output.Type != "sendhec" {
+			return fmt.Errorf("tls settings are not used by %s", output.Type)
+		}
+		// certificate and key files are read by NewSender
+		_, err := tlsconfig.Settings{CipherSuites: output.TLS.CipherSuites, MinVersion: output.TLS.MinVersion}.Config()
+		if err != nil {
+			return fmt.Errorf("tls %v", err)
+		}
+	}
+	if output.Proxy != nil {
+		if output.Type != "sendamqp" && output.Type != "sendhec" {
+			return fmt.Errorf("proxy settings are not used by %s", output.Type)
+		}
+		if output.Proxy.URL == "" {
+			return errors.New("proxy.url is required")
+		}
+		_, err := proxy.Parse(output.Proxy.URL)
+		if err != nil {
+			return fmt.Errorf("proxy.url %v", err)
+		}
+	}
+	if output.Buffer != nil && output.Buffer.Size < 1 {
+		return errors.New("buffer.size must be at least 1")
+	}
+	if output.Retry != nil && output.Retry.Attempts < 1 {
+		return errors.New("retry.attempts must be at least 1")
+	}
+	return nil
+}
+
+var interpolatePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// interpolate replaces ${VAR}, ${VAR:-DEFAULT} and $$ in s.
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	var err error
+	result := interpolatePattern.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+		sub := interpolatePattern.FindStringSubmatch(m)
+		v, ok := lookup(sub[1])
+		if sub[2] != "" {
+			if v == "" {
+				return sub[2][2:]
+			}
+			return v
+		}
+		if !ok && err == nil {
+			err = fmt.Errorf("${%s} is not set", sub[1])
+		}
+		return v
+	})
+	return result, err
+}
+
+// interpolateTree applies interpolate to each string value in a decoded JSON tree.
+// It replaces every reference, returning the first error.
+func interpolateTree(tree interface{}, lookup func(string) (string, bool)) (interface{}, error) {
+	var firstErr error
+	switch v := tree.(type) {
+	case string:
+		return interpolate(v, lookup)
+	case []interface{}:
+		for i := range v {
+			item, err := interpolateTree(v[i], lookup)
+			if firstErr == nil {
+				firstErr = err
+			}
+			v[i] = item
+		}
+	case map[string]interface{}:
+		for k := range v {
+			item, err := interpolateTree(v[k], lookup)
+			if firstErr == nil {
+				firstErr = err
+			}
+			v[k] = item
+		}
+	}
+	return tree, firstErr
+}
+
+func strictUnmarshal(data []byte, v interface{}, disallowUnknown bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after JSON object")
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package fromfile
+
+import (
+	"encoding/json"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/sendbuffer"
+	"github.com/djschaap/logevent/internal/senddump"
+	"github.com/djschaap/logevent/internal/sendhec"
+	"github.com/djschaap/logevent/internal/sendretry"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeLookup(vars map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+}
+
+const testConfig = `{
+	"default": "splunk",
+	"outputs": {
+		"debug": {"type": "senddump", "trace": true},
+		"mq": {
+			"type": "sendamqp",
+			"amqp": {"url": "amqp://${AMQP_USER:-guest}@mq", "exchange": "logs", "ttl": "${TTL}"}
+		},
+		"splunk": {
+			"type": "sendhec",
+			"hec": {"url": "https://splunk:8088", "token": "${HEC_TOKEN}", "ack_timeout": "30s"},
+			"defaults": {"index": "main", "fields": {"team": "ops"}},
+			"retry": {"attempts": 3, "backoff": "10ms"},
+			"buffer": {"size": 100, "flush_interval": 0.5}
+		}
+	}
+}`
+
+func TestParse(t *testing.T) {
+	vars := map[string]string{"HEC_TOKEN": "secret", "TTL": "60"}
+	cfg, err := Parse([]byte(testConfig), fakeLookup(vars))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error %v", err)
+	}
+	if names := strings.Join(cfg.Names(), ","); names != "debug,mq,splunk" {
+		t.Errorf("expected names debug,mq,splunk, got %s", names)
+	}
+	mq := cfg.Outputs["mq"].AMQP
+	if expected := "amqp://guest@mq"; mq.URL != expected {
+		t.Errorf("expected url=%s, got %s", expected, mq.URL)
+	}
+	if mq.TTL.String() != "60" {
+		t.Errorf("expected ttl=60, got %s", mq.TTL)
+	}
+	splunk := cfg.Outputs["splunk"]
+	if splunk.HEC.Token != "secret" {
+		t.Errorf("expected token=secret, got %s", splunk.HEC.Token)
+	}
+	if expected := Duration(30 * time.Second); splunk.HEC.AckTimeout != expected {
+		t.Errorf("expected ack_timeout=%v, got %v", time.Duration(expected), time.Duration(splunk.HEC.AckTimeout))
+	}
+	if expected := Duration(500 * time.Millisecond); splunk.Buffer.FlushInterval != expected {
+		t.Errorf("expected flush_interval=%v, got %v", time.Duration(expected), time.Duration(splunk.Buffer.FlushInterval))
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		message string
+	}{
+		{"unset variable in default", `{"default": "${OUT}", "outputs": {"a": {"type": "senddump"}}}`, "${OUT} is not set"},
+		{"unknown field", `{"outputs": {"a": {"type": "senddump", "colour": "red"}}}`, "unknown field"},
+		{"no outputs", `{"outputs": {}}`, "no outputs"},
+		{"bad default", `{"default": "b", "outputs": {"a": {"type": "senddump"}}}`, `default output "b"`},
+		{"bad type", `{"outputs": {"a": {"type": "sendhek"}}}`, `output a: type "sendhek" is not valid`},
+		{"missing settings", `{"outputs": {"a": {"type": "sendhec"}}}`, "sendhec requires hec settings"},
+		{"wrong settings", `{"outputs": {"a": {"type": "senddump", "sns": {"topic": "t"}}}}`, "sns settings are not used by senddump"},
+		{"missing token", `{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h"}}}}`, "hec.token is required"},
+		{"url and urls", `{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h", "urls": ["https://h2"], "token": "t"}}}}`, "hec.url or hec.urls"},
+		{"bad balance", `{"outputs": {"a": {"type": "sendhec", "hec": {"urls": ["https://h1", "https://h2"], "balance": "random", "token": "t"}}}}`, "hec.balance"},
+		{"bad ack_resends", `{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h", "token": "t", "ack_timeout": 30, "ack_resends": -1}}}}`, "hec.ack_resends"},
+		{"tls with senddump", `{"outputs": {"a": {"type": "senddump", "tls": {"min_version": "1.2"}}}}`, "tls settings are not used by senddump"},
+		{"bad tls version", `{"outputs": {"a": {"type": "sendamqp", "amqp": {"url": "amqps://h"}, "tls": {"min_version": "1.4"}}}}`, `tls minimum version "1.4"`},
+		{"proxy with senddump", `{"outputs": {"a": {"type": "senddump", "proxy": {"url": "http://proxy:3128"}}}}`, "proxy settings are not used by senddump"},
+		{"bad proxy", `{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h", "token": "t"}, "proxy": {"url": "ftp://proxy"}}}}`, `proxy.url scheme "ftp"`},
+		{"bad response_timeout", `{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h", "token": "t", "response_timeout": "-5s"}}}}`, "hec.response_timeout must not be negative"},
+		{"bad ttl", `{"outputs": {"a": {"type": "sendamqp", "amqp": {"url": "amqp://h", "ttl": 1.5}}}}`, "amqp.ttl"},
+		{"bad confirm_timeout", `{"outputs": {"a": {"type": "sendamqp", "amqp": {"url": "amqp://h", "confirm_timeout": "-1s"}}}}`, "amqp.confirm_timeout must not be negative"},
+		{"bad duration", `{"outputs": {"a": {"type": "senddump", "retry": {"attempts": 2, "backoff": "soon"}}}}`, "soon"},
+		{"bad retry", `{"outputs": {"a": {"type": "senddump", "retry": {"attempts": 0}}}}`, "retry.attempts"},
+		{"trailing data", `{"outputs": {"a": {"type": "senddump"}}} {}`, "unexpected data"},
+	}
+	for _, test := range tests {
+		t.Run(test.name,
+			func(t *testing.T) {
+				_, err := Parse([]byte(test.config), fakeLookup(nil))
+				if err == nil {
+					t.Fatal("expected error from Parse() but got nil")
+				}
+				if !strings.Contains(err.Error(), test.message) {
+					t.Errorf("expected error containing %q, got %v", test.message, err)
+				}
+			},
+		)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"A": "1", "EMPTY": ""}
+	tests := []struct {
+		in, expect string
+	}{
+		{"plain", "plain"},
+		{"${A}", "1"},
+		{"x${A}y${A}", "x1y1"},
+		{"${EMPTY}", ""},
+		{"${EMPTY:-d}", "d"},
+		{"${UNSET:-d:e}", "d:e"},
+		{"$$A", "$A"},
+		{"$${A}", "${A}"},
+		{"$A", "$A"},
+	}
+	for _, test := range tests {
+		got, err := interpolate(test.in, fakeLookup(vars))
+		if err != nil {
+			t.Errorf("interpolate(%q) returned unexpected error %v", test.in, err)
+		} else if got != test.expect {
+			t.Errorf("interpolate(%q): expected %q, got %q", test.in, test.expect, got)
+		}
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	vars := map[string]string{"HEC_TOKEN": "secret", "TTL": "60"}
+	cfg, err := Parse([]byte(testConfig), fakeLookup(vars))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error %v", err)
+	}
+
+	t.Run("default output with wrappers",
+		func(t *testing.T) {
+			sender, err := cfg.NewSender("")
+			if err != nil {
+				t.Fatalf("NewSender() returned unexpected error %v", err)
+			}
+			if _, ok := sender.(*sendbuffer.Sess); !ok {
+				t.Errorf("expected *sendbuffer.Sess, got %T", sender)
+			}
+		},
+	)
+
+	t.Run("named output",
+		func(t *testing.T) {
+			sender, err := cfg.NewSender("debug")
+			if err != nil {
+				t.Fatalf("NewSender() returned unexpected error %v", err)
+			}
+			if _, ok := sender.(*senddump.Sess); !ok {
+				t.Errorf("expected *senddump.Sess, got %T", sender)
+			}
+		},
+	)
+
+	t.Run("unknown output",
+		func(t *testing.T) {
+			_, err := cfg.NewSender("nope")
+			if err == nil || !strings.Contains(err.Error(), "outputs are debug, mq, splunk") {
+				t.Errorf("expected error listing outputs, got %v", err)
+			}
+		},
+	)
+
+	t.Run("output with unset variable",
+		func(t *testing.T) {
+			cfg, err := Parse([]byte(testConfig), fakeLookup(map[string]string{"TTL": "60"}))
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			_, err = cfg.NewSender("debug")
+			if err != nil {
+				t.Errorf("NewSender(debug) returned unexpected error %v", err)
+			}
+			_, err = cfg.NewSender("splunk")
+			if expected := "output splunk: ${HEC_TOKEN} is not set"; err == nil || err.Error() != expected {
+				t.Errorf("expected error %q, got %v", expected, err)
+			}
+		},
+	)
+
+	t.Run("single output without default",
+		func(t *testing.T) {
+			cfg, err := Parse([]byte(`{"outputs": {"only": {"type": "sendhec", "hec": {"url": "https://h", "token": "t"}, "retry": {"attempts": 2}}}}`), fakeLookup(nil))
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			sender, err := cfg.NewSender("")
+			if err != nil {
+				t.Fatalf("NewSender() returned unexpected error %v", err)
+			}
+			if _, ok := sender.(*sendretry.Sess); !ok {
+				t.Errorf("expected *sendretry.Sess, got %T", sender)
+			}
+		},
+	)
+
+	t.Run("output with proxy settings",
+		func(t *testing.T) {
+			cfg, err := Parse([]byte(`{"outputs": {"a": {"type": "sendamqp", "amqp": {"url": "amqps://h"}, "proxy": {"url": "socks5://proxy:1080", "no_proxy": "localhost"}}}}`), fakeLookup(nil))
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			_, err = cfg.NewSender("")
+			if err != nil {
+				t.Errorf("NewSender() returned unexpected error %v", err)
+			}
+		},
+	)
+
+	t.Run("output with tls settings",
+		func(t *testing.T) {
+			cfg, err := Parse([]byte(`{"outputs": {"a": {"type": "sendhec", "hec": {"url": "https://h", "token": "t"}, "tls": {"ca_file": "/nonexistent/ca.pem"}}}}`), fakeLookup(nil))
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			_, err = cfg.NewSender("")
+			if err == nil || !strings.Contains(err.Error(), "output a: tls CA file:") {
+				t.Errorf("expected CA file error, got %v", err)
+			}
+		},
+	)
+}
+
+func TestHECSettings_httpSettings(t *testing.T) {
+	var h HECSettings
+	err := json.Unmarshal([]byte(`{"connect_timeout": 0, "keep_alive": false, "max_idle_conns": 5, "response_timeout": "5s"}`), &h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := sendhec.DefaultHTTPSettings()
+	expected.ConnectTimeout = 0
+	expected.DisableKeepAlive = true
+	expected.MaxIdleConns = 5
+	expected.ResponseTimeout = 5 * time.Second
+	if got := h.httpSettings(); got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestDefaultsSender(t *testing.T) {
+	s := &defaultsSender{
+		defaults: Defaults{
+			Fields:     map[string]interface{}{"team": "ops", "zone": "a"},
+			Host:       "h",
+			Index:      "main",
+			Sourcetype: "st",
+		},
+		sender: sendhec.New("https://splunk:8088", "secret"),
+	}
+	logEvent := s.apply(logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Fields:     map[string]interface{}{"zone": "b"},
+			Sourcetype: "mine",
+		},
+	})
+	if logEvent.Attributes.Host != "h" || logEvent.Content.Host != "h" {
+		t.Errorf("expected host=h in attributes and content, got %+v", logEvent)
+	}
+	if logEvent.Content.Index != "main" {
+		t.Errorf("expected index=main, got %s", logEvent.Content.Index)
+	}
+	if logEvent.Content.Sourcetype != "mine" || logEvent.Attributes.Sourcetype != "st" {
+		t.Errorf("expected sourcetype kept and defaulted, got %+v", logEvent)
+	}
+	if logEvent.Content.Fields["team"] != "ops" || logEvent.Content.Fields["zone"] != "b" {
+		t.Errorf("expected merged fields, got %v", logEvent.Content.Fields)
+	}
+
+	payload, err := s.Render(logevent.LogEvent{})
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if !strings.Contains(string(payload.Body), `"index":"main"`) {
+		t.Errorf("expected rendered body to contain default index, got %s", payload.Body)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fromfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{"outputs": {"a": {"type": "bogus"}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Errorf("expected error prefixed with path, got %v", err)
+	}
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("expected error from Load() of missing file but got nil")
+	}
+}
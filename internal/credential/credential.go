@@ -0,0 +1,71 @@
+package credential
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// Provider supplies a secret, such as a HEC token or AMQP password,
+// which may be rotated while a sender is running.
+// Senders call Credential when they connect and after an authentication failure,
+// rather than keeping the secret they were created with.
+type Provider interface {
+	Credential() (string, error)
+}
+
+// File is a Provider which reads the secret from the named file on each call,
+// as with secrets mounted into a container.
+// Leading and trailing whitespace, including a final newline, is removed.
+type File string
+
+// Credential implements Provider.
+func (f File) Credential() (string, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", string(f))
+	}
+	return secret, nil
+}
+
+// Func is a Provider which calls a function on each call.
+type Func func() (string, error)
+
+// Credential implements Provider.
+func (f Func) Credential() (string, error) {
+	return f()
+}
+
+// Static is a Provider which always returns the same secret.
+type Static string
+
+// Credential implements Provider.
+func (s Static) Credential() (string, error) {
+	return string(s), nil
+}
+
+// Mask hides a secret for display, as in dry-run, check-config and SENDER_URL output:
+// all but the last four characters are replaced by stars, or all of a secret of 8 characters or fewer.
+func Mask(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+// MaskURL returns u as a string with its password, if any, masked by Mask.
+func MaskURL(u *url.URL) string {
+	password, ok := u.User.Password()
+	if !ok {
+		return u.String()
+	}
+	// url.URL would escape the stars; the first @ ends the (escaped) username
+	masked := *u
+	masked.User = url.User(u.User.Username())
+	return strings.Replace(masked.String(), "@", ":"+Mask(password)+"@", 1)
+}
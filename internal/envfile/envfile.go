@@ -0,0 +1,19 @@
+package envfile
+
+import (
+	"fmt"
+	"github.com/joho/godotenv"
+	"os"
+)
+
+// Load loads the environment variables in path into the environment,
+// without overriding variables which are already set.
+// A missing file is only an error when it was named explicitly;
+// services normally get their settings from the real environment instead.
+func Load(path string, explicit bool) error {
+	err := godotenv.Load(path)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return fmt.Errorf("loading %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package envfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logevent-envfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.env")
+
+	err = Load(missing, false)
+	if err != nil {
+		t.Errorf("expected no error from missing default file, got %v", err)
+	}
+	err = Load(missing, true)
+	if err == nil {
+		t.Error("expected error from missing explicit file but got nil")
+	}
+
+	present := filepath.Join(dir, "present.env")
+	ioutil.WriteFile(present, []byte("LOGEVENT_ENVFILE_TEST=loaded\n"), 0644)
+	defer os.Unsetenv("LOGEVENT_ENVFILE_TEST")
+	err = Load(present, true)
+	if err != nil {
+		t.Errorf("Load() returned unexpected error %v", err)
+	}
+	if v := os.Getenv("LOGEVENT_ENVFILE_TEST"); v != "loaded" {
+		t.Errorf("expected LOGEVENT_ENVFILE_TEST=loaded, got %q", v)
+	}
+}
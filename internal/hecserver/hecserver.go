@@ -0,0 +1,315 @@
+package hecserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/djschaap/logevent"
+	"github.com/kr/pretty"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HEC response status codes, as documented by Splunk.
+const (
+	codeSuccess              = 0
+	codeTokenRequired        = 2
+	codeInvalidAuthorization = 3
+	codeInvalidToken         = 4
+	codeNoData               = 5
+	codeInvalidDataFormat    = 6
+	codeInternalServerError  = 8
+	codeServerBusy           = 9
+	codeEventFieldRequired   = 12
+	codeEventFieldBlank      = 13
+	codeHealthy              = 17
+)
+
+type hecResponse struct {
+	Text               string `json:"text"`
+	Code               int    `json:"code"`
+	InvalidEventNumber *int   `json:"invalid-event-number,omitempty"`
+}
+
+// hecEvent is a single event as posted to /services/collector/event.
+type hecEvent struct {
+	Event      json.RawMessage        `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+	Host       string                 `json:"host"`
+	Index      string                 `json:"index"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype"`
+	Time       json.RawMessage        `json:"time"`
+}
+
+// Server stores hecserver state; it implements http.Handler.
+type Server struct {
+	maxContentLength int64
+	mtx              sync.Mutex
+	sender           logevent.MessageSender
+	tokens           map[string]bool
+	trace            bool
+}
+
+// ServeHTTP handles the HEC event, raw and health endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	path = strings.TrimSuffix(path, "/1.0")
+	switch path {
+	case "/services/collector/health":
+		writeResponse(w, http.StatusOK, hecResponse{Text: "HEC is healthy", Code: codeHealthy})
+	case "/services/collector", "/services/collector/event":
+		s.serveIngest(w, r, parseEvents)
+	case "/services/collector/raw":
+		s.serveIngest(w, r, parseRaw)
+	default:
+		writeResponse(w, http.StatusNotFound, hecResponse{Text: "The requested URL was not found on this server.", Code: 404})
+	}
+}
+
+// SetMaxContentLength sets the maximum accepted request body size, in bytes.
+func (s *Server) SetMaxContentLength(v int64) {
+	s.maxContentLength = v
+}
+
+// SetTrace enables tracing, which dumps all received events to stderr.
+func (s *Server) SetTrace(v bool) {
+	s.trace = v
+}
+
+func (s *Server) authenticate(r *http.Request) (int, *hecResponse) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return http.StatusUnauthorized, &hecResponse{Text: "Token is required", Code: codeTokenRequired}
+	}
+	var token string
+	if strings.HasPrefix(auth, "Splunk ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Splunk "))
+	} else if strings.HasPrefix(auth, "Basic ") {
+		// HEC accepts the token as the password of HTTP basic authentication
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err == nil {
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) == 2 {
+				token = parts[1]
+			}
+		}
+	}
+	if token == "" {
+		return http.StatusUnauthorized, &hecResponse{Text: "Invalid authorization", Code: codeInvalidAuthorization}
+	}
+	if !s.tokens[token] {
+		return http.StatusForbidden, &hecResponse{Text: "Invalid token", Code: codeInvalidToken}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *Server) forward(logEvents []logevent.LogEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.tracePretty("TRACE_HECSERVER received", len(logEvents),
+		" logEvents =", logEvents)
+	if batchSender, ok := s.sender.(logevent.BatchSender); ok {
+		return batchSender.SendBatch(logEvents)
+	}
+	for _, logEvent := range logEvents {
+		err := s.sender.SendMessage(logEvent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveIngest(
+	w http.ResponseWriter,
+	r *http.Request,
+	parse func(*http.Request, []byte) ([]logevent.LogEvent, int, *hecResponse),
+) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, hecResponse{Text: "Method not allowed", Code: 405})
+		return
+	}
+	if status, resp := s.authenticate(r); resp != nil {
+		writeResponse(w, status, *resp)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.maxContentLength+1))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, hecResponse{Text: "Invalid data format", Code: codeInvalidDataFormat})
+		return
+	}
+	if int64(len(body)) > s.maxContentLength {
+		writeResponse(w, http.StatusRequestEntityTooLarge, hecResponse{Text: "Content too large", Code: 413})
+		return
+	}
+	logEvents, status, resp := parse(r, body)
+	if resp != nil {
+		writeResponse(w, status, *resp)
+		return
+	}
+	err = s.forward(logEvents)
+	if err != nil {
+		log.Println("hecserver: unable to forward events:", err)
+		writeResponse(w, http.StatusServiceUnavailable, hecResponse{Text: "Server is busy", Code: codeServerBusy})
+		return
+	}
+	writeResponse(w, http.StatusOK, hecResponse{Text: "Success", Code: codeSuccess})
+}
+
+func (s *Server) tracePretty(
+	args ...interface{},
+) {
+	if s.trace {
+		pretty.Log(args...)
+	}
+}
+
+// parseEvents parses one or more concatenated HEC JSON events.
+func parseEvents(r *http.Request, body []byte) ([]logevent.LogEvent, int, *hecResponse) {
+	var logEvents []logevent.LogEvent
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for i := 0; ; i++ {
+		var e hecEvent
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, invalidEvent("Invalid data format", codeInvalidDataFormat, i)
+		}
+		logEvent, resp := e.toLogEvent(i)
+		if resp != nil {
+			return nil, http.StatusBadRequest, resp
+		}
+		logEvents = append(logEvents, logEvent)
+	}
+	if len(logEvents) == 0 {
+		return nil, http.StatusBadRequest, &hecResponse{Text: "No data", Code: codeNoData}
+	}
+	return logEvents, http.StatusOK, nil
+}
+
+// parseRaw treats each non-blank line of the body as one event;
+// metadata is taken from the query string.
+func parseRaw(r *http.Request, body []byte) ([]logevent.LogEvent, int, *hecResponse) {
+	query := r.URL.Query()
+	var logEvents []logevent.LogEvent
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		logEvent := newLogEvent(
+			query.Get("host"),
+			query.Get("index"),
+			query.Get("source"),
+			query.Get("sourcetype"),
+		)
+		logEvent.Content.Event = line
+		logEvents = append(logEvents, logEvent)
+	}
+	if len(logEvents) == 0 {
+		return nil, http.StatusBadRequest, &hecResponse{Text: "No data", Code: codeNoData}
+	}
+	return logEvents, http.StatusOK, nil
+}
+
+func (e hecEvent) toLogEvent(i int) (logevent.LogEvent, *hecResponse) {
+	logEvent := newLogEvent(e.Host, e.Index, e.Source, e.Sourcetype)
+	if len(e.Event) == 0 || string(e.Event) == "null" {
+		return logEvent, invalidEvent("Event field is required", codeEventFieldRequired, i)
+	}
+	var event interface{}
+	err := json.Unmarshal(e.Event, &event)
+	if err != nil {
+		return logEvent, invalidEvent("Invalid data format", codeInvalidDataFormat, i)
+	}
+	if str, ok := event.(string); ok && str == "" {
+		return logEvent, invalidEvent("Event field cannot be blank", codeEventFieldBlank, i)
+	}
+	logEvent.Content.Event = event
+	logEvent.Content.Fields = e.Fields
+	if len(e.Time) > 0 {
+		t, err := parseTime(e.Time)
+		if err != nil {
+			return logEvent, invalidEvent("Invalid data format", codeInvalidDataFormat, i)
+		}
+		logEvent.Content.Time = t
+	}
+	return logEvent, nil
+}
+
+func invalidEvent(text string, code int, i int) *hecResponse {
+	return &hecResponse{Text: text, Code: code, InvalidEventNumber: &i}
+}
+
+func newLogEvent(host, index, source, sourcetype string) logevent.LogEvent {
+	return logevent.LogEvent{
+		Attributes: logevent.Attributes{
+			Host:       host,
+			Source:     source,
+			Sourcetype: sourcetype,
+		},
+		Content: logevent.MessageContent{
+			Host:       host,
+			Index:      index,
+			Source:     source,
+			Sourcetype: sourcetype,
+		},
+	}
+}
+
+// parseTime accepts HEC epoch time, as either a JSON number or string,
+// with optional fractional seconds.
+func parseTime(raw json.RawMessage) (time.Time, error) {
+	s := strings.Trim(string(raw), `"`)
+	if s == "" || s == "null" {
+		return time.Time{}, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if f < 0 {
+		return time.Time{}, errors.New("negative time is not valid")
+	}
+	sec, frac := math.Modf(f)
+	// round to microseconds to avoid float noise such as .123999999
+	usec := math.Round(frac * 1e6)
+	return time.Unix(int64(sec), int64(usec)*1000), nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp hecResponse) {
+	b, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// New creates a new hecserver.
+// It requires an open MessageSender and the list of accepted HEC tokens.
+func New(sender logevent.MessageSender, tokens []string) *Server {
+	s := Server{
+		maxContentLength: 1000000,
+		sender:           sender,
+		tokens:           make(map[string]bool),
+	}
+	for _, token := range tokens {
+		if token != "" {
+			s.tokens[token] = true
+		}
+	}
+	return &s
+}
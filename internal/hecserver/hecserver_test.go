@@ -0,0 +1,219 @@
+package hecserver
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/djschaap/logevent"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	err      error
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+func doRequest(s *Server, method, target, token, body string) (int, hecResponse) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Splunk "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var resp hecResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+func TestHealth(t *testing.T) {
+	s := New(&fakeSender{}, []string{"t1"})
+	status, resp := doRequest(s, http.MethodGet, "/services/collector/health", "", "")
+	if status != http.StatusOK || resp.Code != codeHealthy {
+		t.Errorf("expected 200/code %d, got %d/%#v", codeHealthy, status, resp)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := New(&fakeSender{}, []string{"t1"})
+	tests := []struct {
+		name       string
+		auth       string
+		expectCode int
+		expectHTTP int
+	}{
+		{"missing", "", codeTokenRequired, http.StatusUnauthorized},
+		{"malformed", "Bearer x", codeInvalidAuthorization, http.StatusUnauthorized},
+		{"wrong token", "Splunk nope", codeInvalidToken, http.StatusForbidden},
+		{"basic auth", "Basic eDp0MQ==", codeSuccess, http.StatusOK},
+		{"valid token", "Splunk t1", codeSuccess, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name,
+			func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/services/collector/event",
+					strings.NewReader(`{"event":"x"}`))
+				if tt.auth != "" {
+					req.Header.Set("Authorization", tt.auth)
+				}
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, req)
+				var resp hecResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if rec.Code != tt.expectHTTP || resp.Code != tt.expectCode {
+					t.Errorf("expected %d/code %d, got %d/%#v", tt.expectHTTP, tt.expectCode, rec.Code, resp)
+				}
+			},
+		)
+	}
+}
+
+func TestEventEndpoint(t *testing.T) {
+	t.Run("batch of events",
+		func(t *testing.T) {
+			sender := &fakeSender{}
+			s := New(sender, []string{"t1"})
+			body := `{"time":1577836800.5,"host":"h1","index":"idx1","source":"s1","sourcetype":"st1","fields":{"f":"v1"},"event":"one"}
+{"time":"1577836801","event":{"k":"v"}}`
+			status, resp := doRequest(s, http.MethodPost, "/services/collector/event", "t1", body)
+			if status != http.StatusOK || resp.Code != codeSuccess {
+				t.Fatalf("expected success, got %d/%#v", status, resp)
+			}
+			if len(sender.received) != 2 {
+				t.Fatalf("expected 2 events, got %d", len(sender.received))
+			}
+			first := sender.received[0]
+			if first.Content.Event != "one" {
+				t.Errorf("incorrect Event, expected \"one\" got %#v", first.Content.Event)
+			}
+			if first.Content.Host != "h1" || first.Attributes.Host != "h1" {
+				t.Errorf("incorrect Host, got %#v / %#v", first.Content.Host, first.Attributes.Host)
+			}
+			if first.Content.Index != "idx1" {
+				t.Errorf("incorrect Index, got %#v", first.Content.Index)
+			}
+			if first.Content.Sourcetype != "st1" || first.Attributes.Sourcetype != "st1" {
+				t.Errorf("incorrect Sourcetype, got %#v / %#v", first.Content.Sourcetype, first.Attributes.Sourcetype)
+			}
+			if first.Content.Fields["f"] != "v1" {
+				t.Errorf("incorrect Fields[\"f\"], got %#v", first.Content.Fields["f"])
+			}
+			expectTime := time.Unix(1577836800, 500000000)
+			if !first.Content.Time.Equal(expectTime) {
+				t.Errorf("incorrect Time, expected %v got %v", expectTime, first.Content.Time)
+			}
+			second := sender.received[1]
+			if m, ok := second.Content.Event.(map[string]interface{}); !ok || m["k"] != "v" {
+				t.Errorf("incorrect Event, expected object got %#v", second.Content.Event)
+			}
+			if second.Content.Time.Unix() != 1577836801 {
+				t.Errorf("incorrect Time, got %v", second.Content.Time)
+			}
+		},
+	)
+
+	tests := []struct {
+		name          string
+		body          string
+		expectCode    int
+		expectInvalid int
+	}{
+		{"no data", ``, codeNoData, -1},
+		{"invalid json", `{"event":"x"} {"event":`, codeInvalidDataFormat, 1},
+		{"event required", `{"event":"x"}{"host":"h"}`, codeEventFieldRequired, 1},
+		{"event blank", `{"event":""}`, codeEventFieldBlank, 0},
+		{"invalid time", `{"event":"x","time":"yesterday"}`, codeInvalidDataFormat, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name,
+			func(t *testing.T) {
+				sender := &fakeSender{}
+				s := New(sender, []string{"t1"})
+				status, resp := doRequest(s, http.MethodPost, "/services/collector/event", "t1", tt.body)
+				if status != http.StatusBadRequest || resp.Code != tt.expectCode {
+					t.Errorf("expected 400/code %d, got %d/%#v", tt.expectCode, status, resp)
+				}
+				if tt.expectInvalid >= 0 {
+					if resp.InvalidEventNumber == nil || *resp.InvalidEventNumber != tt.expectInvalid {
+						t.Errorf("expected invalid-event-number %d, got %#v", tt.expectInvalid, resp.InvalidEventNumber)
+					}
+				}
+				if len(sender.received) != 0 {
+					t.Errorf("expected nothing forwarded, got %d", len(sender.received))
+				}
+			},
+		)
+	}
+
+	t.Run("sender failure",
+		func(t *testing.T) {
+			s := New(&fakeSender{err: errors.New("down")}, []string{"t1"})
+			status, resp := doRequest(s, http.MethodPost, "/services/collector/event", "t1", `{"event":"x"}`)
+			if status != http.StatusServiceUnavailable || resp.Code != codeServerBusy {
+				t.Errorf("expected 503/code %d, got %d/%#v", codeServerBusy, status, resp)
+			}
+		},
+	)
+
+	t.Run("too large",
+		func(t *testing.T) {
+			s := New(&fakeSender{}, []string{"t1"})
+			s.SetMaxContentLength(5)
+			status, _ := doRequest(s, http.MethodPost, "/services/collector/event", "t1", `{"event":"x"}`)
+			if status != http.StatusRequestEntityTooLarge {
+				t.Errorf("expected 413, got %d", status)
+			}
+		},
+	)
+
+	t.Run("GET not allowed",
+		func(t *testing.T) {
+			s := New(&fakeSender{}, []string{"t1"})
+			status, _ := doRequest(s, http.MethodGet, "/services/collector/event", "t1", "")
+			if status != http.StatusMethodNotAllowed {
+				t.Errorf("expected 405, got %d", status)
+			}
+		},
+	)
+}
+
+func TestRawEndpoint(t *testing.T) {
+	sender := &fakeSender{}
+	s := New(sender, []string{"t1"})
+	status, resp := doRequest(s, http.MethodPost,
+		"/services/collector/raw?host=h1&sourcetype=st1&index=idx1&source=s1",
+		"t1", "line one\r\n\nline two\n")
+	if status != http.StatusOK || resp.Code != codeSuccess {
+		t.Fatalf("expected success, got %d/%#v", status, resp)
+	}
+	if len(sender.received) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(sender.received))
+	}
+	if sender.received[0].Content.Event != "line one" {
+		t.Errorf("incorrect Event, got %#v", sender.received[0].Content.Event)
+	}
+	if sender.received[1].Content.Host != "h1" || sender.received[1].Content.Index != "idx1" {
+		t.Errorf("incorrect metadata, got %#v", sender.received[1].Content)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := New(&fakeSender{}, []string{"t1"})
+	status, _ := doRequest(s, http.MethodPost, "/nope", "t1", "")
+	if status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", status)
+	}
+}
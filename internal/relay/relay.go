@@ -0,0 +1,419 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/sendhec"
+	"github.com/kr/pretty"
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+	"time"
+)
+
+// SenderFactory returns a new, unopened MessageSender.
+// Each relay worker owns its own sender, so senders need not be goroutine-safe.
+type SenderFactory func() (logevent.MessageSender, error)
+
+// Relay stores relay settings; it consumes LogEvents from an AMQP queue and forwards them to a MessageSender.
+type Relay struct {
+	amqpURL         string
+	backoff         time.Duration
+	batchSize       int
+	batchTimeout    time.Duration
+	concurrency     int
+	maxBackoff      time.Duration
+	newSender       SenderFactory
+	prefetch        int
+	queue           string
+	requeue         bool
+	shutdownTimeout time.Duration
+	trace           bool
+}
+
+// Run consumes from the queue until stop is closed or the AMQP connection fails.
+// After stop is closed, in-flight batches are given up to the shutdown timeout to be delivered
+// and acknowledged; anything left unacknowledged is returned to the queue by the broker.
+func (r *Relay) Run(stop <-chan struct{}) error {
+	if r.newSender == nil {
+		return errors.New("Run() requires a SenderFactory")
+	}
+	conn, err := amqp.Dial(r.amqpURL)
+	if err != nil {
+		return fmt.Errorf("amqp.Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	workerStop := make(chan struct{})
+	workerErrs := make(chan error, r.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		ch, err := conn.Channel()
+		if err != nil {
+			close(workerStop)
+			wg.Wait()
+			return fmt.Errorf("amqp.Connection.Channel() failed: %v", err)
+		}
+		w := &worker{
+			relay:       r,
+			consumerTag: fmt.Sprintf("logevent-relay-%d", i),
+			ch:          ch,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.run(workerStop); err != nil {
+				workerErrs <- err
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var runErr error
+	select {
+	case <-stop:
+		r.tracePrintln("relay: stop requested; draining workers")
+	case amqpErr := <-connClosed:
+		runErr = fmt.Errorf("AMQP connection closed unexpectedly: %v", amqpErr)
+	case err := <-workerErrs:
+		runErr = err
+	case <-done:
+		runErr = errors.New("all relay workers stopped unexpectedly")
+	}
+	close(workerStop)
+
+	select {
+	case <-done:
+	case <-time.After(r.shutdownTimeout):
+		log.Println("relay: shutdown timeout exceeded; unacknowledged messages will be redelivered")
+	}
+	if runErr == nil {
+		select {
+		case err := <-workerErrs:
+			runErr = err
+		default:
+		}
+	}
+	return runErr
+}
+
+// SetBackoff sets how long a worker stops consuming after a batch could not be forwarded,
+// so requeued messages are not redelivered in a tight loop while the output is down.
+// The delay doubles after each consecutive failure up to max, and is reset by a success.
+func (r *Relay) SetBackoff(backoff, max time.Duration) {
+	r.backoff = backoff
+	r.maxBackoff = max
+}
+
+// SetBatchSize sets the maximum number of LogEvents forwarded per batch.
+func (r *Relay) SetBatchSize(v int) {
+	if v < 1 {
+		v = 1
+	}
+	r.batchSize = v
+}
+
+// SetBatchTimeout sets how long a partial batch may wait before it is forwarded.
+func (r *Relay) SetBatchTimeout(v time.Duration) {
+	r.batchTimeout = v
+}
+
+// SetConcurrency sets the number of workers; each worker has its own AMQP channel and MessageSender.
+func (r *Relay) SetConcurrency(v int) {
+	if v < 1 {
+		v = 1
+	}
+	r.concurrency = v
+}
+
+// SetPrefetch sets the AMQP prefetch count (per worker).
+// Prefetch should be at least the batch size or batches will never fill.
+func (r *Relay) SetPrefetch(v int) {
+	r.prefetch = v
+}
+
+// SetRequeue selects what happens to a batch which could not be delivered.
+// When true, messages are returned to the queue; when false, they are rejected
+// and will be dead-lettered if the queue has a dead letter exchange.
+func (r *Relay) SetRequeue(v bool) {
+	r.requeue = v
+}
+
+// SetShutdownTimeout sets how long Run waits for in-flight batches after stop is closed.
+func (r *Relay) SetShutdownTimeout(v time.Duration) {
+	r.shutdownTimeout = v
+}
+
+// SetTrace enables tracing, which dumps all messages to stderr.
+func (r *Relay) SetTrace(v bool) {
+	r.trace = v
+}
+
+func (r *Relay) tracePretty(
+	args ...interface{},
+) {
+	if r.trace {
+		pretty.Log(args...)
+	}
+}
+
+func (r *Relay) tracePrintln(
+	args ...interface{},
+) {
+	if r.trace {
+		log.Println(args...)
+	}
+}
+
+type worker struct {
+	ch          *amqp.Channel
+	consumerTag string
+	relay       *Relay
+}
+
+func (w *worker) run(stop <-chan struct{}) error {
+	r := w.relay
+	sender, err := r.newSender()
+	if err != nil {
+		return fmt.Errorf("unable to create sender: %v", err)
+	}
+	err = sender.OpenSvc()
+	if err != nil {
+		return fmt.Errorf("OpenSvc() failed: %v", err)
+	}
+	defer sender.CloseSvc()
+
+	err = w.ch.Qos(r.prefetch, 0, false)
+	if err != nil {
+		return fmt.Errorf("amqp.Channel.Qos() failed: %v", err)
+	}
+	deliveries, err := w.ch.Consume(
+		r.queue,
+		w.consumerTag,
+		false, // autoAck
+		false, // exclusive
+		false, // noLocal
+		false, // noWait
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("amqp.Channel.Consume() failed: %v", err)
+	}
+
+	b := &batch{relay: r, sender: sender}
+	timer := time.NewTimer(r.batchTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				b.flush()
+				return nil
+			}
+			if b.add(d) {
+				b.flush()
+				pause(b.backoff(), stop)
+			}
+		case <-timer.C:
+			b.flush()
+			pause(b.backoff(), stop)
+			timer.Reset(r.batchTimeout)
+		case <-stop:
+			// Cancel stops new deliveries; those already prefetched are still
+			// received and deliveries is closed once they are drained.
+			err := w.ch.Cancel(w.consumerTag, false)
+			if err != nil {
+				b.flush()
+				return fmt.Errorf("amqp.Channel.Cancel() failed: %v", err)
+			}
+			stop = nil
+		}
+	}
+}
+
+// pause waits for d, or until stop is closed, without consuming.
+// Once stop is closed (or nil, while draining), pause returns immediately.
+func pause(d time.Duration, stop <-chan struct{}) {
+	if d <= 0 || stop == nil {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stop:
+	}
+}
+
+// batch accumulates decoded deliveries until they are forwarded together.
+type batch struct {
+	deliveries []amqp.Delivery
+	failures   int // consecutive batches which could not be forwarded
+	logEvents  []logevent.LogEvent
+	relay      *Relay
+	sender     logevent.MessageSender
+}
+
+// add decodes a delivery and appends it to the batch.
+// Deliveries which cannot be decoded are rejected immediately, without requeue,
+// because redelivering them would never succeed.
+// add returns true when the batch is full.
+func (b *batch) add(d amqp.Delivery) bool {
+	logEvent, err := decodeDelivery(d)
+	if err != nil {
+		log.Printf("relay: rejecting undecodable message (delivery tag %d): %v\n", d.DeliveryTag, err)
+		d.Nack(false, false)
+		return false
+	}
+	b.deliveries = append(b.deliveries, d)
+	b.logEvents = append(b.logEvents, logEvent)
+	return len(b.logEvents) >= b.relay.batchSize
+}
+
+// flush forwards the batch, then acknowledges it on success.
+// When HEC rejects one event of the batch, the events before it have been accepted and are acknowledged,
+// that delivery alone is rejected, and the events after it are sent again.
+// When the output rejects the batch outright (a HEC error which is not Retryable), it is rejected
+// without requeue, so it is dead-lettered rather than redelivered forever.
+// On any other failure, the batch is nacked (with requeue, if enabled) and the worker backs off.
+func (b *batch) flush() {
+	if len(b.logEvents) == 0 {
+		return
+	}
+	b.relay.tracePretty("TRACE_RELAY forwarding batch of", len(b.logEvents),
+		" logEvents =", b.logEvents)
+	err := sendBatch(b.sender, b.logEvents)
+	var hecErr *sendhec.Error
+	for err != nil && errors.As(err, &hecErr) && !hecErr.Retryable() &&
+		hecErr.Event >= 0 && hecErr.Event < len(b.deliveries) {
+		i := hecErr.Event
+		log.Printf("relay: rejecting message (delivery tag %d): %v\n", b.deliveries[i].DeliveryTag, err)
+		settle(b.deliveries[:i], func(d amqp.Delivery) error { return d.Ack(false) })
+		settle(b.deliveries[i:i+1], func(d amqp.Delivery) error { return d.Nack(false, false) })
+		b.deliveries = b.deliveries[i+1:]
+		b.logEvents = b.logEvents[i+1:]
+		err = nil
+		if len(b.logEvents) > 0 {
+			err = sendBatch(b.sender, b.logEvents)
+		}
+	}
+	switch {
+	case err == nil:
+		settle(b.deliveries, func(d amqp.Delivery) error { return d.Ack(false) })
+		b.failures = 0
+	case errors.As(err, &hecErr) && !hecErr.Retryable():
+		settle(b.deliveries, func(d amqp.Delivery) error { return d.Nack(false, false) })
+		b.failures = 0
+		log.Printf("relay: rejecting batch of %d: %v\n", len(b.logEvents), err)
+	default:
+		settle(b.deliveries, func(d amqp.Delivery) error { return d.Nack(false, b.relay.requeue) })
+		b.failures++
+		log.Printf("relay: unable to forward batch of %d (requeue=%t); pausing %v: %v\n",
+			len(b.logEvents), b.relay.requeue, b.backoff(), err)
+	}
+	b.deliveries = nil
+	b.logEvents = nil
+}
+
+// settle acknowledges, nacks or rejects each delivery with f, logging failures.
+func settle(deliveries []amqp.Delivery, f func(amqp.Delivery) error) {
+	for _, d := range deliveries {
+		err := f(d)
+		if err != nil {
+			log.Printf("relay: unable to settle delivery tag %d: %v\n", d.DeliveryTag, err)
+		}
+	}
+}
+
+// backoff returns how long to stop consuming after the last flush:
+// 0 after a success, otherwise the relay's backoff doubled for each further consecutive failure.
+func (b *batch) backoff() time.Duration {
+	if b.failures == 0 {
+		return 0
+	}
+	delay := b.relay.backoff
+	for i := 1; i < b.failures; i++ {
+		delay *= 2
+		if b.relay.maxBackoff > 0 && delay >= b.relay.maxBackoff {
+			break
+		}
+	}
+	if b.relay.maxBackoff > 0 && delay > b.relay.maxBackoff {
+		delay = b.relay.maxBackoff
+	}
+	return delay
+}
+
+func decodeDelivery(d amqp.Delivery) (logevent.LogEvent, error) {
+	logEvent := logevent.LogEvent{}
+	if d.ContentType != "" && d.ContentType != "application/json" {
+		return logEvent, fmt.Errorf("unsupported content type %q", d.ContentType)
+	}
+	err := json.Unmarshal(d.Body, &logEvent.Content)
+	if err != nil {
+		return logEvent, fmt.Errorf("unable to parse message body: %v", err)
+	}
+	attr := &logEvent.Attributes
+	attr.CustomerCode = headerString(d.Headers, "customer_code")
+	attr.Host = headerString(d.Headers, "host")
+	attr.Source = headerString(d.Headers, "source")
+	attr.SourceEnvironment = headerString(d.Headers, "source_environment")
+	attr.Sourcetype = headerString(d.Headers, "sourcetype")
+	attr.Type = headerString(d.Headers, "type")
+	if attr.Type == "" {
+		attr.Type = d.Type
+	}
+	return logEvent, nil
+}
+
+func headerString(headers amqp.Table, k string) string {
+	v, ok := headers[k].(string)
+	if !ok {
+		return ""
+	}
+	return v
+}
+
+func sendBatch(sender logevent.MessageSender, logEvents []logevent.LogEvent) error {
+	if batchSender, ok := sender.(logevent.BatchSender); ok {
+		return batchSender.SendBatch(logEvents)
+	}
+	for i, logEvent := range logEvents {
+		err := sender.SendMessage(logEvent)
+		if err != nil {
+			// LogEvents before i were sent; report a rejected LogEvent by its index in logEvents
+			var hecErr *sendhec.Error
+			if i > 0 && errors.As(err, &hecErr) && hecErr.Event >= 0 {
+				hecErr.Event += i
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// New creates a new Relay, with a default backoff of 1s doubling to at most 30s.
+// It requires an AMQP URL/URI, the name of an existing queue, and a SenderFactory for the output.
+func New(amqpURL, queue string, newSender SenderFactory) *Relay {
+	r := Relay{
+		amqpURL:         amqpURL,
+		backoff:         time.Second,
+		batchSize:       100,
+		batchTimeout:    time.Second,
+		concurrency:     1,
+		maxBackoff:      30 * time.Second,
+		newSender:       newSender,
+		prefetch:        100,
+		queue:           queue,
+		requeue:         true,
+		shutdownTimeout: 30 * time.Second,
+	}
+	return &r
+}
@@ -0,0 +1,89 @@
+// +build amqp
+
+package relay
+
+import (
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/sendamqp"
+	"github.com/streadway/amqp"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedSender struct {
+	fakeSender
+	mtx *sync.Mutex
+}
+
+func (s *lockedSender) SendMessage(logEvent logevent.LogEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.fakeSender.SendMessage(logEvent)
+}
+
+func TestRun(t *testing.T) {
+	amqpUrl := os.Getenv("AMQP_URL")
+	conn, err := amqp.Dial(amqpUrl)
+	if err != nil {
+		t.Fatalf("amqp.Dial() returned err: %s", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel() returned err: %s", err)
+	}
+	q, err := ch.QueueDeclare("", false, true, false, false, nil)
+	if err != nil {
+		t.Fatalf("QueueDeclare() returned err: %s", err)
+	}
+
+	publisher := sendamqp.New(amqpUrl, "", q.Name, "")
+	err = publisher.OpenSvc()
+	if err != nil {
+		t.Fatalf("OpenSvc() returned err: %s", err)
+	}
+	defer publisher.CloseSvc()
+	for _, msg := range []string{"one", "two", "three"} {
+		publisher.SendMessage(logevent.LogEvent{
+			Attributes: logevent.Attributes{Host: "h1"},
+			Content:    logevent.MessageContent{Event: msg},
+		})
+	}
+
+	var mtx sync.Mutex
+	sender := &lockedSender{mtx: &mtx}
+	r := New(amqpUrl, q.Name, func() (logevent.MessageSender, error) {
+		return sender, nil
+	})
+	r.SetBatchSize(2)
+	r.SetBatchTimeout(100 * time.Millisecond)
+	r.SetConcurrency(2)
+	r.SetShutdownTimeout(5 * time.Second)
+
+	stop := make(chan struct{})
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run(stop)
+	}()
+	time.Sleep(time.Second)
+	close(stop)
+	err = <-runErr
+	if err != nil {
+		t.Errorf("Run() returned unexpected err: %s", err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(sender.received) != 3 {
+		t.Errorf("expected 3 events relayed, got %d", len(sender.received))
+	}
+	inspect, err := ch.QueueInspect(q.Name)
+	if err != nil {
+		t.Fatalf("QueueInspect() returned err: %s", err)
+	}
+	if inspect.Messages != 0 {
+		t.Errorf("expected empty queue after relay, got %d messages", inspect.Messages)
+	}
+}
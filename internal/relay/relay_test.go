@@ -0,0 +1,379 @@
+package relay
+
+import (
+	"errors"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/sendhec"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+type fakeAcknowledger struct {
+	acked    []uint64
+	nacked   []uint64
+	requeued []uint64
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked = append(a.nacked, tag)
+	if requeue {
+		a.requeued = append(a.requeued, tag)
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type fakeSender struct {
+	err      error
+	received []logevent.LogEvent
+	reject   string // event rejected as invalid, as HEC does
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	if logEvent.Content.Event == s.reject {
+		return &sendhec.Error{StatusCode: 400, Code: 6, Text: "Invalid data format", Event: 0}
+	}
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+func newDelivery(ack amqp.Acknowledger, tag uint64, body string) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger: ack,
+		Body:         []byte(body),
+		ContentType:  "application/json",
+		DeliveryTag:  tag,
+		Headers: amqp.Table{
+			"host":   "h1",
+			"source": "s1",
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	obj := New("amqp://localhost", "q", nil)
+	if obj.queue != "q" {
+		t.Errorf("expected queue=q, got %s", obj.queue)
+	}
+	if obj.batchSize != 100 {
+		t.Errorf("expected batchSize=100, got %d", obj.batchSize)
+	}
+	if obj.concurrency != 1 {
+		t.Errorf("expected concurrency=1, got %d", obj.concurrency)
+	}
+	if obj.requeue != true {
+		t.Error("expected requeue=true")
+	}
+	err := obj.Run(make(chan struct{}))
+	if err == nil {
+		t.Error("expected error from Run() without SenderFactory but got nil")
+	}
+}
+
+func TestSetters(t *testing.T) {
+	obj := New("amqp://localhost", "q", nil)
+	obj.SetBackoff(time.Millisecond, time.Second)
+	if obj.backoff != time.Millisecond || obj.maxBackoff != time.Second {
+		t.Errorf("expected backoff=1ms maxBackoff=1s, got %v %v", obj.backoff, obj.maxBackoff)
+	}
+	obj.SetBatchSize(0)
+	if obj.batchSize != 1 {
+		t.Errorf("expected batchSize=1, got %d", obj.batchSize)
+	}
+	obj.SetBatchTimeout(5 * time.Second)
+	if obj.batchTimeout != 5*time.Second {
+		t.Errorf("expected batchTimeout=5s, got %v", obj.batchTimeout)
+	}
+	obj.SetConcurrency(4)
+	if obj.concurrency != 4 {
+		t.Errorf("expected concurrency=4, got %d", obj.concurrency)
+	}
+	obj.SetPrefetch(7)
+	if obj.prefetch != 7 {
+		t.Errorf("expected prefetch=7, got %d", obj.prefetch)
+	}
+	obj.SetRequeue(false)
+	if obj.requeue != false {
+		t.Error("expected requeue=false")
+	}
+	obj.SetShutdownTimeout(time.Second)
+	if obj.shutdownTimeout != time.Second {
+		t.Errorf("expected shutdownTimeout=1s, got %v", obj.shutdownTimeout)
+	}
+	obj.SetTrace(true)
+	obj.tracePretty("test tracePretty output")
+	obj.tracePrintln("test tracePrintln output")
+}
+
+func Test_batch(t *testing.T) {
+	t.Run("full batch is acked after delivery",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{}
+			r := New("u", "q", nil)
+			r.SetBatchSize(2)
+			b := &batch{relay: r, sender: sender}
+			if b.add(newDelivery(ack, 1, `{"event":"one"}`)) {
+				t.Error("expected batch to not be full after first add")
+			}
+			if !b.add(newDelivery(ack, 2, `{"event":"two"}`)) {
+				t.Error("expected batch to be full after second add")
+			}
+			if len(ack.acked) != 0 {
+				t.Errorf("expected no acks before flush, got %v", ack.acked)
+			}
+			b.flush()
+			if len(sender.received) != 2 {
+				t.Fatalf("expected 2 events sent, got %d", len(sender.received))
+			}
+			if sender.received[1].Content.Event != "two" {
+				t.Errorf("expected second event \"two\", got %#v", sender.received[1].Content.Event)
+			}
+			if len(ack.acked) != 2 {
+				t.Errorf("expected 2 acks, got %v", ack.acked)
+			}
+			if len(b.logEvents) != 0 || len(b.deliveries) != 0 {
+				t.Error("expected empty batch after flush")
+			}
+		},
+	)
+
+	t.Run("failed batch is requeued",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{err: errors.New("down")}
+			r := New("u", "q", nil)
+			b := &batch{relay: r, sender: sender}
+			b.add(newDelivery(ack, 1, `{"event":"one"}`))
+			b.flush()
+			if len(ack.acked) != 0 {
+				t.Errorf("expected no acks, got %v", ack.acked)
+			}
+			if len(ack.requeued) != 1 {
+				t.Errorf("expected 1 requeued nack, got %v", ack.requeued)
+			}
+		},
+	)
+
+	t.Run("failed batches back off",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{err: errors.New("down")}
+			r := New("u", "q", nil)
+			r.SetBackoff(time.Second, 5*time.Second)
+			b := &batch{relay: r, sender: sender}
+			if b.backoff() != 0 {
+				t.Errorf("expected no backoff before a failure, got %v", b.backoff())
+			}
+			for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+				b.add(newDelivery(ack, 1, `{"event":"one"}`))
+				b.flush()
+				if b.backoff() != expected {
+					t.Errorf("expected backoff %v after %d failures, got %v", expected, b.failures, b.backoff())
+				}
+			}
+			sender.err = nil
+			b.add(newDelivery(ack, 2, `{"event":"two"}`))
+			b.flush()
+			if b.backoff() != 0 {
+				t.Errorf("expected no backoff after a success, got %v", b.backoff())
+			}
+		},
+	)
+
+	t.Run("batch rejected by HEC is dead-lettered, not requeued",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{err: &sendhec.Error{StatusCode: 403, Code: 4, Text: "Invalid token", Event: -1}}
+			r := New("u", "q", nil)
+			b := &batch{relay: r, sender: sender}
+			b.add(newDelivery(ack, 1, `{"event":"one"}`))
+			b.flush()
+			if len(ack.nacked) != 1 || len(ack.requeued) != 0 {
+				t.Errorf("expected 1 nack without requeue, got nacked=%v requeued=%v", ack.nacked, ack.requeued)
+			}
+			if b.backoff() != 0 {
+				t.Errorf("expected no backoff after a rejection, got %v", b.backoff())
+			}
+		},
+	)
+
+	t.Run("event rejected by HEC is dead-lettered and the rest are forwarded",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{reject: "bad"}
+			r := New("u", "q", nil)
+			b := &batch{relay: r, sender: sender}
+			b.add(newDelivery(ack, 1, `{"event":"one"}`))
+			b.add(newDelivery(ack, 2, `{"event":"bad"}`))
+			b.add(newDelivery(ack, 3, `{"event":"three"}`))
+			b.add(newDelivery(ack, 4, `{"event":"bad"}`))
+			b.flush()
+			if len(sender.received) != 2 || sender.received[1].Content.Event != "three" {
+				t.Errorf("expected events one and three sent once each, got %v", sender.received)
+			}
+			if len(ack.acked) != 2 || ack.acked[0] != 1 || ack.acked[1] != 3 {
+				t.Errorf("expected acks of 1 and 3, got %v", ack.acked)
+			}
+			if len(ack.nacked) != 2 || ack.nacked[0] != 2 || ack.nacked[1] != 4 || len(ack.requeued) != 0 {
+				t.Errorf("expected nacks of 2 and 4 without requeue, got nacked=%v requeued=%v", ack.nacked, ack.requeued)
+			}
+		},
+	)
+
+	t.Run("failed batch is dead-lettered without requeue",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{err: errors.New("down")}
+			r := New("u", "q", nil)
+			r.SetRequeue(false)
+			b := &batch{relay: r, sender: sender}
+			b.add(newDelivery(ack, 1, `{"event":"one"}`))
+			b.flush()
+			if len(ack.nacked) != 1 || len(ack.requeued) != 0 {
+				t.Errorf("expected 1 nack without requeue, got nacked=%v requeued=%v", ack.nacked, ack.requeued)
+			}
+		},
+	)
+
+	t.Run("undecodable message is rejected",
+		func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			sender := &fakeSender{}
+			r := New("u", "q", nil)
+			b := &batch{relay: r, sender: sender}
+			b.add(newDelivery(ack, 1, `not json`))
+			if len(ack.nacked) != 1 || len(ack.requeued) != 0 {
+				t.Errorf("expected 1 nack without requeue, got nacked=%v requeued=%v", ack.nacked, ack.requeued)
+			}
+			b.flush()
+			if len(sender.received) != 0 {
+				t.Errorf("expected nothing sent, got %d", len(sender.received))
+			}
+		},
+	)
+}
+
+func Test_pause(t *testing.T) {
+	t.Run("waits",
+		func(t *testing.T) {
+			start := time.Now()
+			pause(20*time.Millisecond, make(chan struct{}))
+			if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+				t.Errorf("expected pause of at least 20ms, got %v", elapsed)
+			}
+		},
+	)
+
+	t.Run("ends when stopped",
+		func(t *testing.T) {
+			stop := make(chan struct{})
+			close(stop)
+			start := time.Now()
+			pause(time.Minute, stop)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected pause to end at stop, got %v", elapsed)
+			}
+		},
+	)
+}
+
+func Test_decodeDelivery(t *testing.T) {
+	d := amqp.Delivery{
+		Body:        []byte(`{"host":"h1","index":"idx1","time":"2020-01-01T00:00:00Z","fields":{"f":"v1"},"event":"my event"}`),
+		ContentType: "application/json",
+		Headers: amqp.Table{
+			"customer_code":      "c1",
+			"host":               "h1",
+			"source":             "s1",
+			"source_environment": "se",
+			"sourcetype":         "st1",
+		},
+		Type: "t1",
+	}
+	logEvent, err := decodeDelivery(d)
+	if err != nil {
+		t.Fatalf("decodeDelivery() returned unexpected error %v", err)
+	}
+	t.Run("Attributes",
+		func(t *testing.T) {
+			attr := logEvent.Attributes
+			if attr.CustomerCode != "c1" {
+				t.Errorf("incorrect CustomerCode, expected c1 got %#v", attr.CustomerCode)
+			}
+			if attr.Host != "h1" {
+				t.Errorf("incorrect Host, expected h1 got %#v", attr.Host)
+			}
+			if attr.Source != "s1" {
+				t.Errorf("incorrect Source, expected s1 got %#v", attr.Source)
+			}
+			if attr.SourceEnvironment != "se" {
+				t.Errorf("incorrect SourceEnvironment, expected se got %#v", attr.SourceEnvironment)
+			}
+			if attr.Sourcetype != "st1" {
+				t.Errorf("incorrect Sourcetype, expected st1 got %#v", attr.Sourcetype)
+			}
+			if attr.Type != "t1" {
+				t.Errorf("incorrect Type, expected t1 got %#v", attr.Type)
+			}
+		},
+	)
+	t.Run("Content",
+		func(t *testing.T) {
+			c := logEvent.Content
+			if c.Host != "h1" {
+				t.Errorf("incorrect Host, expected h1 got %#v", c.Host)
+			}
+			if c.Index != "idx1" {
+				t.Errorf("incorrect Index, expected idx1 got %#v", c.Index)
+			}
+			if c.Event != "my event" {
+				t.Errorf("incorrect Event, expected \"my event\" got %#v", c.Event)
+			}
+			if c.Fields["f"] != "v1" {
+				t.Errorf("incorrect Fields[\"f\"], expected v1 got %#v", c.Fields["f"])
+			}
+			expectTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			if !c.Time.Equal(expectTime) {
+				t.Errorf("incorrect Time, expected %v got %v", expectTime, c.Time)
+			}
+		},
+	)
+	t.Run("metric",
+		func(t *testing.T) {
+			logEvent, err := decodeDelivery(amqp.Delivery{
+				Body: []byte(`{"metric":{"dimensions":{"region":"us-east-1"},"values":{"cpu.idle":95.5}}}`),
+			})
+			if err != nil {
+				t.Fatalf("decodeDelivery() returned unexpected error %v", err)
+			}
+			m := logEvent.Content.Metric
+			if m == nil || m.Dimensions["region"] != "us-east-1" || m.Values["cpu.idle"] != 95.5 {
+				t.Errorf("incorrect Metric, got %#v", m)
+			}
+		},
+	)
+	t.Run("unsupported content type",
+		func(t *testing.T) {
+			_, err := decodeDelivery(amqp.Delivery{ContentType: "text/plain", Body: []byte("x")})
+			if err == nil {
+				t.Error("expected error but got nil")
+			}
+		},
+	)
+}
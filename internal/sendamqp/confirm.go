@@ -0,0 +1,152 @@
+package sendamqp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+// ConfirmError is returned by SendMessage and SendBatch in confirm mode (see SetConfirm)
+// when the broker does not confirm a message.
+type ConfirmError struct {
+	Event   int           // index of the LogEvent in the SendBatch call (0 for SendMessage); the first, when several failed
+	Nack    bool          // true when the broker rejected the message; false when it was not confirmed within Timeout
+	Timeout time.Duration // the confirm timeout
+}
+
+func (e *ConfirmError) Error() string {
+	if e.Nack {
+		return fmt.Sprintf("AMQP broker rejected (nacked) event %d", e.Event)
+	}
+	return fmt.Sprintf("AMQP broker did not confirm event %d within %v", e.Event, e.Timeout)
+}
+
+// SetConfirm enables publisher confirms: the channel is put into confirm mode when the session
+// is opened, and SendMessage and SendBatch return only once the broker acknowledges each message,
+// or a *ConfirmError when it rejects one or does not answer within timeout.
+// A timeout of 0 disables publisher confirms.
+func (sender *Sess) SetConfirm(timeout time.Duration) {
+	sender.confirmTimeout = timeout
+}
+
+// confirmations correlates the broker's confirmations with the LogEvents of one send.
+type confirmations struct {
+	pending map[uint64]int // LogEvent index by delivery tag, until confirmed
+	nacked  int            // lowest index of a LogEvent the broker rejected, or -1
+}
+
+func newConfirmations() *confirmations {
+	return &confirmations{pending: make(map[uint64]int), nacked: -1}
+}
+
+// record records the confirmation of the message with delivery tag tag.
+func (cs *confirmations) record(tag uint64, ack bool) {
+	i, ok := cs.pending[tag]
+	if !ok {
+		return
+	}
+	delete(cs.pending, tag)
+	if !ack && (cs.nacked < 0 || i < cs.nacked) {
+		cs.nacked = i
+	}
+}
+
+// confirmer receives the broker's confirmations in its own goroutine, so the client, which
+// delivers them from the connection's reader, is never blocked, even by confirmations which
+// arrive after a send stopped waiting. It ends when the client closes confirms.
+type confirmer struct {
+	mtx      sync.Mutex
+	closed   bool            // the channel closed
+	min      uint64          // lower delivery tags belong to earlier sends, and are discarded
+	notify   chan struct{}   // holds a value after received or closed changes
+	received map[uint64]bool // ack (true) or nack by delivery tag, until taken by a send
+}
+
+func newConfirmer(confirms <-chan amqp.Confirmation) *confirmer {
+	c := &confirmer{notify: make(chan struct{}, 1), received: make(map[uint64]bool)}
+	go c.run(confirms)
+	return c
+}
+
+func (c *confirmer) run(confirms <-chan amqp.Confirmation) {
+	for confirmation := range confirms {
+		c.mtx.Lock()
+		if confirmation.DeliveryTag >= c.min {
+			c.received[confirmation.DeliveryTag] = confirmation.Ack
+		}
+		c.mtx.Unlock()
+		c.signal()
+	}
+	c.mtx.Lock()
+	c.closed = true
+	c.mtx.Unlock()
+	c.signal()
+}
+
+func (c *confirmer) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// discardBefore discards confirmations for delivery tags below tag, the first of a new send.
+func (c *confirmer) discardBefore(tag uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.min = tag
+	for t := range c.received {
+		if t < tag {
+			delete(c.received, t)
+		}
+	}
+}
+
+// take records the confirmations received for the pending messages of cs,
+// returning false once the channel has closed.
+func (c *confirmer) take(cs *confirmations) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for tag := range cs.pending {
+		if ack, ok := c.received[tag]; ok {
+			cs.record(tag, ack)
+			delete(c.received, tag)
+		}
+	}
+	return !c.closed
+}
+
+// waitForConfirms waits until the broker has confirmed each pending LogEvent, up to confirmTimeout.
+func (sender *Sess) waitForConfirms(cs *confirmations) error {
+	timer := time.NewTimer(sender.confirmTimeout)
+	defer timer.Stop()
+	for {
+		open := sender.confirmer.take(cs)
+		if len(cs.pending) == 0 {
+			break
+		}
+		if !open {
+			return errors.New("AMQP channel closed before the broker confirmed all messages")
+		}
+		select {
+		case <-sender.confirmer.notify:
+		case <-timer.C:
+			if cs.nacked >= 0 {
+				return &ConfirmError{Event: cs.nacked, Nack: true}
+			}
+			first := -1
+			for _, i := range cs.pending {
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+			return &ConfirmError{Event: first, Timeout: sender.confirmTimeout}
+		}
+	}
+	if cs.nacked >= 0 {
+		return &ConfirmError{Event: cs.nacked, Nack: true}
+	}
+	return nil
+}
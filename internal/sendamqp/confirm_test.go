@@ -0,0 +1,131 @@
+package sendamqp
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestSetConfirm(t *testing.T) {
+	obj := New("u", "e", "rk", "")
+	if obj.confirmTimeout != 0 {
+		t.Errorf("expected initial confirmTimeout=0, got %v", obj.confirmTimeout)
+	}
+	obj.SetConfirm(5 * time.Second)
+	if obj.confirmTimeout != 5*time.Second {
+		t.Errorf("expected post-change confirmTimeout=5s, got %v", obj.confirmTimeout)
+	}
+}
+
+func Test_waitForConfirms(t *testing.T) {
+	// newTest returns a sender awaiting confirmation of LogEvents 0-2 as delivery tags 11-13,
+	// with the confirmations delivered by the client, which then closes the channel if closed
+	newTest := func(closed bool, delivered ...amqp.Confirmation) (*Sess, *confirmations) {
+		obj := New("u", "e", "rk", "")
+		obj.SetConfirm(20 * time.Millisecond)
+		confirms := make(chan amqp.Confirmation, len(delivered))
+		for _, c := range delivered {
+			confirms <- c
+		}
+		if closed {
+			close(confirms)
+		}
+		obj.confirmer = newConfirmer(confirms)
+		obj.confirmer.discardBefore(11)
+		cs := newConfirmations()
+		for i := 0; i < 3; i++ {
+			cs.pending[uint64(11+i)] = i
+		}
+		return obj, cs
+	}
+
+	t.Run("all acknowledged",
+		func(t *testing.T) {
+			obj, cs := newTest(false,
+				amqp.Confirmation{DeliveryTag: 7, Ack: false}, // from an earlier send
+				amqp.Confirmation{DeliveryTag: 11, Ack: true},
+				amqp.Confirmation{DeliveryTag: 12, Ack: true},
+				amqp.Confirmation{DeliveryTag: 13, Ack: true},
+			)
+			err := obj.waitForConfirms(cs)
+			if err != nil {
+				t.Errorf("waitForConfirms() returned unexpected error %v", err)
+			}
+		},
+	)
+
+	t.Run("nacked",
+		func(t *testing.T) {
+			obj, cs := newTest(false,
+				amqp.Confirmation{DeliveryTag: 11, Ack: true},
+				amqp.Confirmation{DeliveryTag: 13, Ack: false},
+				amqp.Confirmation{DeliveryTag: 12, Ack: false},
+			)
+			err := obj.waitForConfirms(cs)
+			confirmErr, ok := err.(*ConfirmError)
+			if !ok || !confirmErr.Nack || confirmErr.Event != 1 {
+				t.Errorf("expected nack of event 1, got %v", err)
+			}
+		},
+	)
+
+	t.Run("timeout",
+		func(t *testing.T) {
+			obj, cs := newTest(false, amqp.Confirmation{DeliveryTag: 11, Ack: true})
+			err := obj.waitForConfirms(cs)
+			expected := "AMQP broker did not confirm event 1 within 20ms"
+			if _, ok := err.(*ConfirmError); !ok || err.Error() != expected {
+				t.Errorf("expected %q, got %v", expected, err)
+			}
+		},
+	)
+
+	t.Run("channel closed",
+		func(t *testing.T) {
+			obj, cs := newTest(true)
+			err := obj.waitForConfirms(cs)
+			if _, ok := err.(*ConfirmError); err == nil || ok {
+				t.Errorf("expected channel closed error, got %v", err)
+			}
+		},
+	)
+
+	t.Run("late confirmations do not block the client",
+		func(t *testing.T) {
+			confirms := make(chan amqp.Confirmation)
+			defer close(confirms)
+			obj := New("u", "e", "rk", "")
+			obj.SetConfirm(20 * time.Millisecond)
+			obj.confirmer = newConfirmer(confirms)
+			obj.confirmer.discardBefore(1)
+			cs := newConfirmations()
+			cs.pending[1] = 0
+			cs.pending[2] = 1
+			err := obj.waitForConfirms(cs)
+			if _, ok := err.(*ConfirmError); !ok {
+				t.Fatalf("expected timeout, got %v", err)
+			}
+			for _, tag := range []uint64{1, 2} {
+				select {
+				case confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}:
+				case <-time.After(time.Second):
+					t.Fatalf("client blocked delivering late confirmation %d", tag)
+				}
+			}
+			obj.confirmer.discardBefore(3)
+			cs = newConfirmations()
+			cs.pending[3] = 0
+			confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+			err = obj.waitForConfirms(cs)
+			if err != nil {
+				t.Errorf("waitForConfirms() returned unexpected error %v", err)
+			}
+			obj.confirmer.mtx.Lock()
+			n := len(obj.confirmer.received)
+			obj.confirmer.mtx.Unlock()
+			if n != 0 {
+				t.Errorf("expected late confirmations discarded, got %d", n)
+			}
+		},
+	)
+}
@@ -1,16 +1,26 @@
 package sendamqp
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/credential"
+	"github.com/djschaap/logevent/internal/proxy"
 	"github.com/kr/pretty"
 	"github.com/streadway/amqp"
 	"log"
+	"net"
+	"net/url"
 	"strconv"
+	"time"
 )
 
+// dialTimeout limits how long connecting, including the TLS and AMQP handshakes, may take,
+// as amqp.Dial does.
+const dialTimeout = 30 * time.Second
+
 // Sess stores sendamqp session state.
 type Sess struct {
 	amqpChan          *amqp.Channel
@@ -20,8 +30,14 @@ type Sess struct {
 	amqpRoutingKey    string
 	amqpTtl           string
 	amqpURL           string
+	confirmTimeout    time.Duration
+	confirmer         *confirmer // in confirm mode, while the session is open
+	deliveryTag       uint64     // of the last message published in confirm mode
 	openHasBeenCalled bool
+	proxy             proxy.Func
+	tlsConfig         *tls.Config
 	trace             bool
+	urlProvider       credential.Provider
 }
 
 // CloseSvc closes the open session.
@@ -33,6 +49,7 @@ func (sender *Sess) CloseSvc() error {
 	sender.amqpChan = nil
 	sender.amqpConn.Close()
 	sender.amqpConn = nil
+	sender.confirmer = nil
 	sender.openHasBeenCalled = false
 	return nil
 }
@@ -43,9 +60,9 @@ func (sender *Sess) OpenSvc() error {
 	if sender.amqpConn != nil || sender.amqpChan != nil {
 		return errors.New("OpenSvc() called again; that should not be done")
 	}
-	conn, err := amqp.Dial(sender.amqpURL)
+	conn, err := sender.dial()
 	if err != nil {
-		return fmt.Errorf("amqp.Dial() failed: %v", err)
+		return err
 	}
 	sender.amqpConn = conn
 	sender.amqpError = conn.NotifyClose(make(chan *amqp.Error))
@@ -54,6 +71,14 @@ func (sender *Sess) OpenSvc() error {
 	if err != nil {
 		return fmt.Errorf("amqp.Connection.Channel() failed: %v", err)
 	}
+	if sender.confirmTimeout > 0 {
+		err = ch.Confirm(false)
+		if err != nil {
+			return fmt.Errorf("amqp.Channel.Confirm() failed: %v", err)
+		}
+		sender.confirmer = newConfirmer(ch.NotifyPublish(make(chan amqp.Confirmation, 1)))
+		sender.deliveryTag = 0
+	}
 	sender.amqpChan = ch
 	sender.openHasBeenCalled = true
 
@@ -62,40 +87,85 @@ func (sender *Sess) OpenSvc() error {
 	return nil
 }
 
-// SendMessage sends a LogEvent to a RabbitMQ (AMQP) exchange.
-func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
-	if sender.amqpChan == nil {
-		err := sender.reopenSvcAfterErr()
+// Probe connects to the AMQP server and, unless publishing to the default exchange,
+// checks that the exchange exists.
+func (sender *Sess) Probe() error {
+	conn, err := sender.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("amqp.Connection.Channel() failed: %v", err)
+	}
+	defer ch.Close()
+	if sender.amqpExchange != "" {
+		// the exchange kind is ignored by a passive declare
+		err = ch.ExchangeDeclarePassive(sender.amqpExchange, amqp.ExchangeHeaders, false, false, false, false, nil)
 		if err != nil {
-			return fmt.Errorf("Implicit reconnect from sendamqp.SendMessage() failed: %s", err)
+			return fmt.Errorf("exchange %s: %v", sender.amqpExchange, err)
 		}
-		log.Println("sendamqp.SendMessage() reconnected to MQ")
-		// beware: OpenSvc MUST be called explicitly, from our caller/parent, the
-		//   first time to ensure `defer sender.CloseSvc()` occurs
 	}
+	return nil
+}
 
-	select {
-	case err := <-sender.amqpError:
-		closeErr := sender.closeSvcAfterErr()
-		if closeErr != nil {
-			return fmt.Errorf("AMQP connection closed unexpectedly: %s; ALSO got error from CloseSvc: %s", err, closeErr)
-		} else {
-			return fmt.Errorf("AMQP connection closed unexpectedly: %s", err)
-		}
-	default:
+// Render returns the message SendMessage would publish for a LogEvent.
+// Target includes the AMQP URL, with any password masked, exchange and routing key.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	amqpMessage := sender.buildAmqpMessage(logEvent)
+	headers := make(map[string]string, len(amqpMessage.Headers))
+	for k, v := range amqpMessage.Headers {
+		headers[k] = fmt.Sprint(v)
+	}
+	properties := map[string]string{
+		"content_type":  amqpMessage.ContentType,
+		"delivery_mode": strconv.Itoa(int(amqpMessage.DeliveryMode)),
 	}
+	if amqpMessage.Expiration != "" {
+		properties["expiration"] = amqpMessage.Expiration
+	}
+	if amqpMessage.Type != "" {
+		properties["type"] = amqpMessage.Type
+	}
+	payload := logevent.Payload{
+		Body:       amqpMessage.Body,
+		Headers:    headers,
+		Properties: properties,
+		Target: fmt.Sprintf("%s exchange=%q routing_key=%q",
+			maskURLPassword(sender.amqpURL), sender.amqpExchange, sender.amqpRoutingKey),
+	}
+	return payload, nil
+}
 
-	amqpMessage := sender.buildAmqpMessage(logEvent)
-	sender.amqpChan.Publish(
-		sender.amqpExchange,
-		sender.amqpRoutingKey,
-		false, // mandatory
-		false, // immediate
-		amqpMessage,
-	)
-	sender.tracePretty("TRACE_SENDAMQP amqpMessage:", amqpMessage,
-		"\nBody:", string(amqpMessage.Body))
-	return nil
+// SendBatch publishes several LogEvents to a RabbitMQ (AMQP) exchange.
+// In confirm mode, it waits once for the broker to confirm them all.
+func (sender *Sess) SendBatch(logEvents []logevent.LogEvent) error {
+	return sender.publish("SendBatch", logEvents)
+}
+
+// SendMessage sends a LogEvent to a RabbitMQ (AMQP) exchange.
+func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
+	return sender.publish("SendMessage", []logevent.LogEvent{logEvent})
+}
+
+// SetURLProvider sets a source for the AMQP URL, including its password, which is read
+// each time a connection is made. After the password is rotated, the next reconnect
+// (such as after ACCESS_REFUSED or a dropped connection) uses the new one without a restart.
+func (sender *Sess) SetURLProvider(p credential.Provider) {
+	sender.urlProvider = p
+}
+
+// SetProxy sets the proxy for the AMQP server, in place of HTTPS_PROXY (for amqps:// URLs),
+// HTTP_PROXY (for amqp:// URLs) and NO_PROXY (see proxy.Fixed); nil restores them.
+func (sender *Sess) SetProxy(p proxy.Func) {
+	sender.proxy = p
+}
+
+// SetTLSConfig sets the TLS configuration for amqps:// URLs, such as a CA, client certificate
+// or minimum version (see tlsconfig.Settings). It is not used with amqp:// URLs.
+func (sender *Sess) SetTLSConfig(c *tls.Config) {
+	sender.tlsConfig = c
 }
 
 // SetTrace enables tracing, which dumps all messages to stderr.
@@ -147,6 +217,114 @@ func (sender *Sess) buildAmqpMessage(logEvent logevent.LogEvent) amqp.Publishing
 	return amqpMessage
 }
 
+// dial connects to the AMQP server, first reading the URL from urlProvider, if set.
+func (sender *Sess) dial() (*amqp.Connection, error) {
+	if sender.urlProvider != nil {
+		amqpURL, err := sender.urlProvider.Credential()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read AMQP URL: %v", err)
+		}
+		sender.amqpURL = amqpURL
+	}
+	p := sender.proxy
+	if p == nil {
+		p = proxy.FromEnvironment
+	}
+	scheme := "amqp"
+	if u, err := url.Parse(sender.amqpURL); err == nil {
+		scheme = u.Scheme
+	}
+	conn, err := amqp.DialConfig(sender.amqpURL, amqp.Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := proxy.Dial(p, scheme, addr, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			// cleared by amqp once the connection is open
+			conn.SetDeadline(time.Now().Add(dialTimeout))
+			return conn, nil
+		},
+		Heartbeat:       10 * time.Second,
+		Locale:          "en_US",
+		TLSClientConfig: sender.tlsConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("amqp.Dial() failed: %v", err)
+	}
+	return conn, nil
+}
+
+// maskURLPassword masks the password, if any, in a URL.
+func maskURLPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return credential.MaskURL(u)
+}
+
+// publish publishes logEvents, reconnecting first when the connection was lost,
+// and waits for the broker to confirm them in confirm mode. caller names the method for errors.
+func (sender *Sess) publish(caller string, logEvents []logevent.LogEvent) error {
+	if sender.amqpChan == nil {
+		err := sender.reopenSvcAfterErr()
+		if err != nil {
+			return fmt.Errorf("Implicit reconnect from sendamqp.%s() failed: %s", caller, err)
+		}
+		log.Printf("sendamqp.%s() reconnected to MQ\n", caller)
+		// beware: OpenSvc MUST be called explicitly, from our caller/parent, the
+		//   first time to ensure `defer sender.CloseSvc()` occurs
+	}
+
+	select {
+	case err := <-sender.amqpError:
+		closeErr := sender.closeSvcAfterErr()
+		if closeErr != nil {
+			return fmt.Errorf("AMQP connection closed unexpectedly: %s; ALSO got error from CloseSvc: %s", err, closeErr)
+		} else {
+			return fmt.Errorf("AMQP connection closed unexpectedly: %s", err)
+		}
+	default:
+	}
+
+	cs := newConfirmations()
+	if sender.confirmer != nil {
+		sender.confirmer.discardBefore(sender.deliveryTag + 1)
+	}
+	for i, logEvent := range logEvents {
+		amqpMessage := sender.buildAmqpMessage(logEvent)
+		err := sender.amqpChan.Publish(
+			sender.amqpExchange,
+			sender.amqpRoutingKey,
+			false, // mandatory
+			false, // immediate
+			amqpMessage,
+		)
+		if err != nil {
+			sender.closeSvcAfterErr() // reconnect on the next send
+			return fmt.Errorf("amqp.Channel.Publish() failed: %v", err)
+		}
+		sender.tracePretty("TRACE_SENDAMQP amqpMessage:", amqpMessage,
+			"\nBody:", string(amqpMessage.Body))
+		if sender.confirmer != nil {
+			sender.deliveryTag++
+			cs.pending[sender.deliveryTag] = i
+		}
+	}
+	if sender.confirmer == nil {
+		return nil
+	}
+	err := sender.waitForConfirms(cs)
+	if err != nil {
+		if _, ok := err.(*ConfirmError); !ok {
+			sender.closeSvcAfterErr() // the channel closed; reconnect on the next send
+		}
+		return err
+	}
+	sender.tracePrintln("TRACE_SENDAMQP broker confirmed", len(logEvents), "messages")
+	return nil
+}
+
 func (sender *Sess) closeSvcAfterErr() error {
 	if sender.amqpConn == nil {
 		return errors.New("closeSvcAfterErr() called again or before OpenSvc(); that should not be done")
@@ -154,6 +332,7 @@ func (sender *Sess) closeSvcAfterErr() error {
 	sender.amqpChan = nil
 	sender.amqpConn.Close()
 	sender.amqpConn = nil
+	sender.confirmer = nil
 	// leave sender.openHasBeenCalled = true
 	return nil
 }
@@ -4,6 +4,7 @@ package sendamqp
 
 import (
 	"github.com/djschaap/logevent"
+	"github.com/streadway/amqp"
 	"os"
 	"testing"
 	"time"
@@ -108,3 +109,94 @@ func TestSendMessage_simple(t *testing.T) {
 	obj.SendMessage(logEvent)
 	// FUTURE consume message
 }
+
+func TestProbe(t *testing.T) {
+	amqpUrl := os.Getenv("AMQP_URL")
+	err := New(amqpUrl, "amq.headers", "rk", "").Probe()
+	if err != nil {
+		t.Errorf("Probe() returned unexpected error %v", err)
+	}
+	err = New(amqpUrl, "exch-does-not-exist", "rk", "").Probe()
+	if err == nil {
+		t.Error("expected error from Probe() with missing exchange but got nil")
+	}
+}
+
+func TestSetConfirm_broker(t *testing.T) {
+	amqpUrl := os.Getenv("AMQP_URL")
+	logEvents := []logevent.LogEvent{
+		{Content: logevent.MessageContent{Event: "first"}},
+		{Content: logevent.MessageContent{Event: "second"}},
+	}
+
+	t.Run("acknowledged",
+		func(t *testing.T) {
+			obj := New(amqpUrl, "amq.headers", "sendamqp_amqp_test_discard", "")
+			obj.SetConfirm(5 * time.Second)
+			err := obj.OpenSvc()
+			if err != nil {
+				t.Fatalf("OpenSvc() returned unexpected err: %s", err)
+			}
+			defer obj.CloseSvc()
+			err = obj.SendMessage(logEvents[0])
+			if err != nil {
+				t.Errorf("SendMessage() returned unexpected error %v", err)
+			}
+			err = obj.SendBatch(logEvents)
+			if err != nil {
+				t.Errorf("SendBatch() returned unexpected error %v", err)
+			}
+		},
+	)
+
+	t.Run("nacked",
+		func(t *testing.T) {
+			// a queue which holds one message rejects (nacks) the next
+			conn, err := amqp.Dial(amqpUrl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ch, err := conn.Channel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := ch.QueueDeclare("", false, true, true, false, amqp.Table{
+				"x-max-length": int32(1),
+				"x-overflow":   "reject-publish",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			obj := New(amqpUrl, "", q.Name, "")
+			obj.SetConfirm(5 * time.Second)
+			err = obj.OpenSvc()
+			if err != nil {
+				t.Fatalf("OpenSvc() returned unexpected err: %s", err)
+			}
+			defer obj.CloseSvc()
+			err = obj.SendBatch(logEvents)
+			confirmErr, ok := err.(*ConfirmError)
+			if !ok || !confirmErr.Nack || confirmErr.Event != 1 {
+				t.Errorf("expected nack of event 1, got %v", err)
+			}
+		},
+	)
+
+	t.Run("missing exchange",
+		func(t *testing.T) {
+			obj := New(amqpUrl, "exch-does-not-exist", "rk", "")
+			obj.SetConfirm(5 * time.Second)
+			err := obj.OpenSvc()
+			if err != nil {
+				t.Fatalf("OpenSvc() returned unexpected err: %s", err)
+			}
+			defer obj.CloseSvc()
+			err = obj.SendMessage(logEvents[0])
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+		},
+	)
+}
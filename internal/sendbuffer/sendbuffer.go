@@ -0,0 +1,182 @@
+package sendbuffer
+
+import (
+	"errors"
+	"github.com/djschaap/logevent"
+	"log"
+	"sync"
+	"time"
+)
+
+// Sess stores sendbuffer session state.
+type Sess struct {
+	batchSize     int
+	done          chan struct{}
+	err           error // first error from a background send; returned by the next call
+	flushInterval time.Duration
+	mtx           sync.Mutex
+	queue         chan logevent.LogEvent
+	sender        logevent.MessageSender
+	size          int
+	trace         bool
+}
+
+// CloseSvc sends any buffered LogEvents, then closes the wrapped sender.
+// It returns the first error from a background send which has not yet been returned.
+func (sender *Sess) CloseSvc() error {
+	if sender.queue != nil {
+		close(sender.queue)
+		<-sender.done
+		sender.queue = nil
+	}
+	err := sender.takeErr()
+	closeErr := sender.sender.CloseSvc()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// OpenSvc opens the wrapped sender and starts sending buffered LogEvents in the background.
+func (sender *Sess) OpenSvc() error {
+	err := sender.sender.OpenSvc()
+	if err != nil {
+		return err
+	}
+	sender.queue = make(chan logevent.LogEvent, sender.size)
+	sender.done = make(chan struct{})
+	go sender.loop(sender.queue, sender.done)
+	return nil
+}
+
+// Probe probes the wrapped sender, if it supports probing.
+func (sender *Sess) Probe() error {
+	prober, ok := sender.sender.(logevent.Prober)
+	if !ok {
+		return errors.New("wrapped sender does not support Probe")
+	}
+	return prober.Probe()
+}
+
+// Render renders with the wrapped sender, if it supports rendering.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	renderer, ok := sender.sender.(logevent.Renderer)
+	if !ok {
+		return logevent.Payload{}, errors.New("wrapped sender does not support Render")
+	}
+	return renderer.Render(logEvent)
+}
+
+// SendMessage adds a LogEvent to the buffer, blocking while the buffer is full.
+// Delivery happens in the background, so an error returned here may belong to an earlier LogEvent.
+func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
+	if sender.queue == nil {
+		return errors.New("sendbuffer: SendMessage called before OpenSvc")
+	}
+	err := sender.takeErr()
+	if err != nil {
+		return err
+	}
+	sender.queue <- logEvent
+	return nil
+}
+
+// SetBatch sets the most LogEvents sent together, and how long a LogEvent may wait in the buffer.
+func (sender *Sess) SetBatch(batchSize int, flushInterval time.Duration) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	sender.batchSize = batchSize
+	sender.flushInterval = flushInterval
+}
+
+// SetTrace enables tracing of background sends, and tracing in the wrapped sender.
+func (sender *Sess) SetTrace(v bool) {
+	sender.trace = v
+	sender.sender.SetTrace(v)
+}
+
+func (sender *Sess) flush(batch []logevent.LogEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	if batchSender, ok := sender.sender.(logevent.BatchSender); ok {
+		err = batchSender.SendBatch(batch)
+	} else {
+		for _, logEvent := range batch {
+			err = sender.sender.SendMessage(logEvent)
+			if err != nil {
+				break
+			}
+		}
+	}
+	sender.tracePrintln("TRACE_SENDBUFFER sent", len(batch), "events, err:", err)
+	if err != nil {
+		sender.mtx.Lock()
+		if sender.err == nil {
+			sender.err = err
+		}
+		sender.mtx.Unlock()
+	}
+}
+
+func (sender *Sess) loop(queue <-chan logevent.LogEvent, done chan<- struct{}) {
+	defer close(done)
+	var tick <-chan time.Time
+	if sender.flushInterval > 0 {
+		ticker := time.NewTicker(sender.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	batch := make([]logevent.LogEvent, 0, sender.batchSize)
+	for {
+		select {
+		case logEvent, ok := <-queue:
+			if !ok {
+				sender.flush(batch)
+				return
+			}
+			batch = append(batch, logEvent)
+			if len(batch) >= sender.batchSize {
+				sender.flush(batch)
+				batch = make([]logevent.LogEvent, 0, sender.batchSize)
+			}
+		case <-tick:
+			sender.flush(batch)
+			batch = make([]logevent.LogEvent, 0, sender.batchSize)
+		}
+	}
+}
+
+func (sender *Sess) takeErr() error {
+	sender.mtx.Lock()
+	defer sender.mtx.Unlock()
+	err := sender.err
+	sender.err = nil
+	return err
+}
+
+func (sender *Sess) tracePrintln(
+	args ...interface{},
+) {
+	if sender.trace {
+		log.Println(args...)
+	}
+}
+
+// New creates a new sendbuffer object/session.
+// It wraps sender, holding up to size LogEvents (at least 1) which are sent
+// in the background in batches of up to 100, at least once per second.
+func New(sender logevent.MessageSender, size int) *Sess {
+	if size < 1 {
+		size = 1
+	}
+	sess := Sess{
+		batchSize:     100,
+		flushInterval: time.Second,
+		sender:        sender,
+		size:          size,
+	}
+	return &sess
+}
@@ -0,0 +1,119 @@
+package sendbuffer
+
+import (
+	"errors"
+	"github.com/djschaap/logevent"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	batches  []int
+	closed   bool
+	failures int
+	mtx      sync.Mutex
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { s.closed = true; return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	return s.SendBatch([]logevent.LogEvent{logEvent})
+}
+func (s *fakeSender) SendBatch(logEvents []logevent.LogEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("down")
+	}
+	s.batches = append(s.batches, len(logEvents))
+	s.received = append(s.received, logEvents...)
+	return nil
+}
+
+func (s *fakeSender) count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.received)
+}
+
+func TestNew(t *testing.T) {
+	var _ logevent.MessageSender = New(&fakeSender{}, 10)
+	if New(&fakeSender{}, 0).size != 1 {
+		t.Error("expected size to be at least 1")
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	t.Run("batches and flushes on close",
+		func(t *testing.T) {
+			inner := &fakeSender{}
+			sess := New(inner, 10)
+			sess.SetBatch(2, 0)
+			sess.OpenSvc()
+			for i := 0; i < 5; i++ {
+				err := sess.SendMessage(logevent.LogEvent{})
+				if err != nil {
+					t.Errorf("SendMessage() returned unexpected error %v", err)
+				}
+			}
+			err := sess.CloseSvc()
+			if err != nil {
+				t.Errorf("CloseSvc() returned unexpected error %v", err)
+			}
+			if len(inner.received) != 5 {
+				t.Errorf("expected 5 events, got %d", len(inner.received))
+			}
+			if len(inner.batches) != 3 || inner.batches[2] != 1 {
+				t.Errorf("expected batches [2 2 1], got %v", inner.batches)
+			}
+			if !inner.closed {
+				t.Error("expected wrapped sender to be closed")
+			}
+		},
+	)
+
+	t.Run("flushes on interval",
+		func(t *testing.T) {
+			inner := &fakeSender{}
+			sess := New(inner, 10)
+			sess.SetBatch(100, 10*time.Millisecond)
+			sess.OpenSvc()
+			defer sess.CloseSvc()
+			sess.SendMessage(logevent.LogEvent{})
+			deadline := time.Now().Add(time.Second)
+			for inner.count() == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if inner.count() != 1 {
+				t.Errorf("expected 1 event before close, got %d", inner.count())
+			}
+		},
+	)
+
+	t.Run("reports background error",
+		func(t *testing.T) {
+			inner := &fakeSender{failures: 1}
+			sess := New(inner, 10)
+			sess.SetBatch(1, 0)
+			sess.OpenSvc()
+			sess.SendMessage(logevent.LogEvent{})
+			err := sess.CloseSvc()
+			if err == nil {
+				t.Error("expected error from CloseSvc() but got nil")
+			}
+		},
+	)
+
+	t.Run("before OpenSvc",
+		func(t *testing.T) {
+			err := New(&fakeSender{}, 1).SendMessage(logevent.LogEvent{})
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+		},
+	)
+}
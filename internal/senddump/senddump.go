@@ -34,6 +34,17 @@ func (sender *Sess) OpenSvc() error {
 	return nil
 }
 
+// Render returns the dump SendMessage would write for a LogEvent when tracing is enabled.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	timeString := logEvent.Content.Time.UTC().Format(time.RFC3339)
+	logEvent.Content.Time = time.Time{}
+	payload := logevent.Payload{
+		Body:   []byte(pretty.Sprint("TRACE_SENDER time =", timeString, " logEvent =", logEvent)),
+		Target: "stderr",
+	}
+	return payload, nil
+}
+
 // SendMessage dumps a LogEvent to stderr when tracing is enabled.
 // No output is generated unless tracing is enabled.
 func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
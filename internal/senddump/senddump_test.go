@@ -3,6 +3,7 @@ package senddump
 import (
 	"github.com/djschaap/logevent"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -46,6 +47,25 @@ func TestRepeatedOpenAndClose(t *testing.T) {
 	}
 }
 
+func TestRender(t *testing.T) {
+	obj := New()
+	var _ logevent.Renderer = obj
+	payload, err := obj.Render(logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Event: "my event",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if payload.Target != "stderr" {
+		t.Errorf("incorrect Target, got %s", payload.Target)
+	}
+	if !strings.Contains(string(payload.Body), `"my event"`) {
+		t.Errorf("expected Body to contain event, got %s", payload.Body)
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	obj := New()
 	logEvent := logevent.LogEvent{}
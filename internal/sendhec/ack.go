@@ -0,0 +1,103 @@
+package sendhec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultAckPollInterval is how often postWithAck asks HEC whether a request has been indexed.
+const defaultAckPollInterval = time.Second
+
+// SetAck enables indexer acknowledgment, which must also be enabled for the HEC token.
+// Requests are sent on a channel (X-Splunk-Request-Channel) chosen when the session is opened,
+// and SendMessage and SendBatch return only once HEC reports the events indexed.
+// A request which is not acknowledged within timeout is sent again, up to resends times.
+// A timeout of 0 disables indexer acknowledgment.
+func (sender *Sess) SetAck(timeout time.Duration, resends int) {
+	sender.ackTimeout = timeout
+	sender.ackResends = resends
+}
+
+// postWithAck posts body, which holds count events, then waits until HEC reports them indexed,
+// re-sending them after each ackTimeout without acknowledgment.
+func (sender *Sess) postWithAck(hecURL, endpoint string, body []byte, count int) error {
+	for attempt := 0; attempt <= sender.ackResends; attempt++ {
+		if attempt > 0 {
+			sender.tracePrintln("TRACE_SENDHEC no acknowledgment after", sender.ackTimeout,
+				"; re-sending batch of", count, "events")
+		}
+		reply, err := sender.postHEC(hecURL, endpoint, body)
+		if err != nil {
+			return err
+		}
+		if reply.AckID == nil {
+			return errors.New("HEC did not return an ackId; is indexer acknowledgment enabled for the token?")
+		}
+		acked, err := sender.waitForAck(hecURL, *reply.AckID)
+		if err != nil {
+			return err
+		}
+		if acked {
+			return nil
+		}
+	}
+	return fmt.Errorf("batch of %d events was not acknowledged within %v (%d attempts)",
+		count, sender.ackTimeout, sender.ackResends+1)
+}
+
+// waitForAck polls HEC until ackID is acknowledged, returning false after ackTimeout.
+func (sender *Sess) waitForAck(hecURL string, ackID int) (bool, error) {
+	deadline := time.Now().Add(sender.ackTimeout)
+	body, _ := json.Marshal(map[string][]int{"acks": {ackID}})
+	key := strconv.Itoa(ackID)
+	for {
+		reply, err := sender.postHEC(hecURL, "/services/collector/ack", body)
+		if err != nil {
+			return false, err
+		}
+		if reply.Acks[key] {
+			sender.tracePrintln("TRACE_SENDHEC ackId", ackID, "indexed")
+			return true, nil
+		}
+		if time.Now().Add(sender.ackPollInterval).After(deadline) {
+			return false, nil
+		}
+		time.Sleep(sender.ackPollInterval)
+	}
+}
+
+// requestHeaders returns the headers of a request to HEC, with token as the HEC token.
+// Before OpenSvc, which chooses the channel, the channel is a placeholder.
+func (sender *Sess) requestHeaders(token string) map[string]string {
+	channel := sender.channel
+	if channel == "" {
+		channel = "<assigned on OpenSvc>"
+	}
+	return map[string]string{
+		"Authorization":            "Splunk " + token,
+		"X-Splunk-Request-Channel": channel,
+	}
+}
+
+// postHEC posts body to an endpoint of hecURL, which may include a query, on the session's channel.
+// A response other than HTTP 200 is returned as an *Error.
+func (sender *Sess) postHEC(hecURL, endpoint string, body []byte) (*reply, error) {
+	req, err := http.NewRequest(http.MethodPost, hecURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range sender.requestHeaders(sender.hecToken) {
+		req.Header.Set(k, v)
+	}
+	res, err := sender.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return readReply(hecURL, res)
+}
@@ -0,0 +1,176 @@
+package sendhec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAckHEC is a HEC server with indexer acknowledgment enabled.
+// Requests from the ackFrom'th on are acknowledged after ackAfter polls; with ackAfter < 0, never.
+type fakeAckHEC struct {
+	ackAfter int
+	ackFrom  int
+	channels map[string]bool
+	mtx      sync.Mutex
+	noAckID  bool
+	polls    map[int]int
+	requests []string
+}
+
+func newFakeAckHEC(ackAfter int) (*fakeAckHEC, *httptest.Server) {
+	f := &fakeAckHEC{
+		ackAfter: ackAfter,
+		channels: make(map[string]bool),
+		polls:    make(map[int]int),
+	}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeAckHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	channel := r.Header.Get("X-Splunk-Request-Channel")
+	if channel == "" {
+		channel = r.URL.Query().Get("channel")
+	}
+	if channel == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"Data channel is missing","code":10}`))
+		return
+	}
+	f.channels[channel] = true
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/services/collector", "/services/collector/raw":
+		f.requests = append(f.requests, string(body))
+		if f.noAckID {
+			w.Write([]byte(`{"text":"Success","code":0}`))
+			return
+		}
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, len(f.requests)-1)
+	case "/services/collector/ack":
+		var req struct {
+			Acks []int `json:"acks"`
+		}
+		json.Unmarshal(body, &req)
+		acks := make(map[string]bool)
+		for _, id := range req.Acks {
+			f.polls[id]++
+			acks[strconv.Itoa(id)] = f.ackAfter >= 0 && id >= f.ackFrom && f.polls[id] > f.ackAfter
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newAckSess(url string, timeout time.Duration, resends int) *Sess {
+	obj := New(url, "t")
+	obj.SetAck(timeout, resends)
+	obj.ackPollInterval = time.Millisecond
+	return obj
+}
+
+func TestSetAck(t *testing.T) {
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}}
+
+	t.Run("acknowledged after polling",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(2)
+			defer ts.Close()
+			obj := newAckSess(ts.URL, time.Second, 0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendBatch([]logevent.LogEvent{logEvent, logEvent, {}})
+			if err != nil {
+				t.Fatalf("SendBatch() returned unexpected error %v", err)
+			}
+			if len(f.requests) != 1 || strings.Count(f.requests[0], `"event":"e"`) != 2 {
+				t.Errorf("expected 1 request with 2 events, got %q", f.requests)
+			}
+			if f.polls[0] != 3 {
+				t.Errorf("expected 3 polls, got %d", f.polls[0])
+			}
+			if len(f.channels) != 1 || !f.channels[obj.channel] {
+				t.Errorf("expected requests on channel %s, got %v", obj.channel, f.channels)
+			}
+		},
+	)
+
+	t.Run("re-sent after timeout",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(0)
+			defer ts.Close()
+			f.ackFrom = 1 // the first request is never acknowledged
+			obj := newAckSess(ts.URL, 5*time.Millisecond, 1)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err != nil {
+				t.Fatalf("SendMessage() returned unexpected error %v", err)
+			}
+			if len(f.requests) != 2 {
+				t.Errorf("expected 2 requests, got %d", len(f.requests))
+			}
+		},
+	)
+
+	t.Run("never acknowledged",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(-1)
+			defer ts.Close()
+			obj := newAckSess(ts.URL, 5*time.Millisecond, 2)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err == nil || !strings.Contains(err.Error(), "not acknowledged") {
+				t.Errorf("expected not acknowledged error, got %v", err)
+			}
+			if len(f.requests) != 3 {
+				t.Errorf("expected 3 requests, got %d", len(f.requests))
+			}
+		},
+	)
+
+	t.Run("acknowledgment not enabled",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(0)
+			defer ts.Close()
+			f.noAckID = true
+			obj := newAckSess(ts.URL, time.Second, 0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err == nil || !strings.Contains(err.Error(), "did not return an ackId") {
+				t.Errorf("expected missing ackId error, got %v", err)
+			}
+		},
+	)
+
+	t.Run("disabled",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(-1)
+			defer ts.Close()
+			f.noAckID = true
+			obj := New(ts.URL, "t")
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err != nil {
+				t.Fatalf("SendMessage() returned unexpected error %v", err)
+			}
+			if len(f.requests) != 1 || len(f.polls) != 0 {
+				t.Errorf("expected 1 request and no polls, got %d and %v", len(f.requests), f.polls)
+			}
+		},
+	)
+}
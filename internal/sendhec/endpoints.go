@@ -0,0 +1,278 @@
+package sendhec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultHealthInterval is how often the health of each HEC URL is checked, when there are several.
+const defaultHealthInterval = 10 * time.Second
+
+// Balance selects how a session with several HEC URLs chooses one for each request.
+type Balance int
+
+const (
+	// RoundRobin uses each healthy URL in turn.
+	RoundRobin Balance = iota
+	// LeastLatency uses the healthy URL with the lowest average response time.
+	LeastLatency
+)
+
+// ParseBalance parses round-robin or least-latency; empty is round-robin.
+func ParseBalance(s string) (Balance, error) {
+	switch s {
+	case "", "round-robin":
+		return RoundRobin, nil
+	case "least-latency":
+		return LeastLatency, nil
+	}
+	return RoundRobin, fmt.Errorf("%q is not valid; use round-robin or least-latency", s)
+}
+
+func (b Balance) String() string {
+	if b == LeastLatency {
+		return "least-latency"
+	}
+	return "round-robin"
+}
+
+// EndpointStatus is the state of one HEC URL, as returned by Endpoints.
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool          // false while the URL is out of rotation
+	Latency   time.Duration // average response time, from requests and health checks
+	Failures  int           // failed requests and health checks since the URL was last healthy
+	LastError string        // why the URL was taken out of rotation
+	Since     time.Time     // when Healthy last changed
+}
+
+// endpoint is one HEC URL of an open session.
+// Its status is guarded by Sess.endpointMtx.
+type endpoint struct {
+	status EndpointStatus
+}
+
+// Endpoints returns the status of each HEC URL while the session is open.
+func (sender *Sess) Endpoints() []EndpointStatus {
+	sender.endpointMtx.Lock()
+	defer sender.endpointMtx.Unlock()
+	var statuses []EndpointStatus
+	for _, e := range sender.endpoints {
+		statuses = append(statuses, e.status)
+	}
+	return statuses
+}
+
+// SetBalance selects how requests are distributed among several HEC URLs (default RoundRobin).
+func (sender *Sess) SetBalance(b Balance) {
+	sender.balance = b
+}
+
+// SetHealthCheck sets how often each of several HEC URLs is checked (GET /services/collector/health).
+// A URL is taken out of rotation when its health check or a request to it fails,
+// or it reports that it is busy (HTTP 503), and is returned to rotation when its health check passes.
+// An interval of 0 disables health checks; a failed URL is then retried only when all others have failed.
+func (sender *Sess) SetHealthCheck(interval time.Duration) {
+	sender.healthInterval = interval
+}
+
+// SetURLs sets several HEC URLs, such as for a set of heavy forwarders,
+// in place of the URL given to New. Requests are distributed among them (see SetBalance),
+// and a request which fails because of its URL is sent to another.
+func (sender *Sess) SetURLs(urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+	sender.hecURL = urls[0]
+	sender.hecURLs = urls
+}
+
+// openEndpoints creates an endpoint for each HEC URL,
+// and starts checking their health when there are several.
+func (sender *Sess) openEndpoints() {
+	now := time.Now()
+	sender.endpointMtx.Lock()
+	sender.endpoints = nil
+	for _, url := range sender.hecURLs {
+		sender.endpoints = append(sender.endpoints, &endpoint{
+			status: EndpointStatus{URL: url, Healthy: true, Since: now},
+		})
+	}
+	sender.endpointMtx.Unlock()
+	if len(sender.endpoints) > 1 && sender.healthInterval > 0 {
+		sender.healthStop = make(chan struct{})
+		sender.healthDone = make(chan struct{})
+		go sender.checkHealth(sender.healthStop, sender.healthDone)
+	}
+}
+
+// closeEndpoints stops health checks and discards the endpoints.
+func (sender *Sess) closeEndpoints() {
+	if sender.healthStop != nil {
+		close(sender.healthStop)
+		<-sender.healthDone
+		sender.healthStop = nil
+		sender.healthDone = nil
+	}
+	sender.endpointMtx.Lock()
+	sender.endpoints = nil
+	sender.endpointMtx.Unlock()
+}
+
+// pickEndpoint returns the endpoint for the next request, excluding those already tried.
+// Unhealthy endpoints are picked only when no healthy one remains; nil when all have been tried.
+func (sender *Sess) pickEndpoint(tried map[*endpoint]bool) *endpoint {
+	sender.endpointMtx.Lock()
+	defer sender.endpointMtx.Unlock()
+	n := len(sender.endpoints)
+	var choice, fallback *endpoint
+	for i := 0; i < n; i++ {
+		e := sender.endpoints[(sender.next+i)%n]
+		if tried[e] {
+			continue
+		}
+		if !e.status.Healthy {
+			if fallback == nil {
+				fallback = e
+			}
+			continue
+		}
+		if choice == nil || (sender.balance == LeastLatency && e.status.Latency < choice.status.Latency) {
+			choice = e
+		}
+		if sender.balance == RoundRobin {
+			break
+		}
+	}
+	if choice == nil {
+		choice = fallback
+	}
+	if sender.balance == RoundRobin {
+		sender.next = (sender.next + 1) % n
+	}
+	return choice
+}
+
+// withEndpoint calls send with an endpoint, then with others in turn while send fails because of
+// the endpoint, until all have been tried. Each endpoint which fails is taken out of rotation;
+// one which succeeds is returned to it. An endpoint which refuses the request itself, such as
+// its token or events, is left as it was.
+func (sender *Sess) withEndpoint(send func(*endpoint) error) error {
+	tried := make(map[*endpoint]bool)
+	var err error
+	for {
+		e := sender.pickEndpoint(tried)
+		if e == nil {
+			return err
+		}
+		tried[e] = true
+		start := time.Now()
+		err = send(e)
+		if err != nil && isEndpointError(err) {
+			sender.markDown(e, err)
+			continue
+		}
+		if err == nil {
+			sender.markUp(e, time.Since(start))
+		}
+		return err
+	}
+}
+
+// isEndpointError returns true when a request failed because of the HEC URL it was sent to:
+// the connection failed, or HEC answered with a retryable error. Errors in the request itself,
+// such as events which cannot be encoded or a token without indexer acknowledgment, are not.
+func isEndpointError(err error) bool {
+	var hecErr *Error
+	if errors.As(err, &hecErr) {
+		return hecErr.Retryable()
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// markDown takes e out of rotation.
+func (sender *Sess) markDown(e *endpoint, err error) {
+	sender.endpointMtx.Lock()
+	defer sender.endpointMtx.Unlock()
+	e.status.Failures++
+	e.status.LastError = err.Error()
+	if e.status.Healthy {
+		if len(sender.endpoints) > 1 {
+			log.Printf("sendhec: %s out of rotation: %v\n", e.status.URL, err)
+		}
+		e.status.Healthy = false
+		e.status.Since = time.Now()
+	}
+}
+
+// markUp returns e to rotation and includes latency in its average response time.
+func (sender *Sess) markUp(e *endpoint, latency time.Duration) {
+	sender.endpointMtx.Lock()
+	defer sender.endpointMtx.Unlock()
+	if e.status.Latency == 0 {
+		e.status.Latency = latency
+	} else {
+		e.status.Latency = (3*e.status.Latency + latency) / 4
+	}
+	if !e.status.Healthy {
+		if len(sender.endpoints) > 1 {
+			log.Printf("sendhec: %s back in rotation\n", e.status.URL)
+		}
+		e.status.Healthy = true
+		e.status.Since = time.Now()
+		e.status.Failures = 0
+		e.status.LastError = ""
+	}
+}
+
+// checkHealth checks each endpoint every healthInterval until stop is closed.
+func (sender *Sess) checkHealth(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	client := sender.newHTTPClient()
+	client.Timeout = probeTimeout
+	ticker := time.NewTicker(sender.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		sender.endpointMtx.Lock()
+		endpoints := append([]*endpoint(nil), sender.endpoints...)
+		sender.endpointMtx.Unlock()
+		for _, e := range endpoints {
+			start := time.Now()
+			err := healthCheck(client, e.status.URL)
+			if err != nil {
+				sender.markDown(e, err)
+			} else {
+				sender.markUp(e, time.Since(start))
+			}
+		}
+	}
+}
+
+// healthCheck returns an error unless hecURL reports that it is healthy.
+func healthCheck(client *http.Client, hecURL string) error {
+	res, err := client.Get(hecURL + "/services/collector/health")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned HTTP %d", res.StatusCode)
+	}
+	return nil
+}
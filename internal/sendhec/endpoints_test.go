@@ -0,0 +1,267 @@
+package sendhec
+
+import (
+	"github.com/djschaap/logevent"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint is a HEC URL which counts events and can be made busy or slow.
+type fakeEndpoint struct {
+	busy   bool
+	delay  time.Duration
+	events int
+	mtx    sync.Mutex
+}
+
+func (f *fakeEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	busy := f.busy
+	f.mtx.Unlock()
+	time.Sleep(f.delay)
+	if busy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"text":"Server is busy","code":9}`))
+		return
+	}
+	if r.URL.Path == "/services/collector/health" {
+		w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+		return
+	}
+	f.mtx.Lock()
+	f.events++
+	f.mtx.Unlock()
+	w.Write([]byte(`{"text":"Success","code":0}`))
+}
+
+func (f *fakeEndpoint) setBusy(v bool) {
+	f.mtx.Lock()
+	f.busy = v
+	f.mtx.Unlock()
+}
+
+func (f *fakeEndpoint) count() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.events
+}
+
+func newFakeEndpoints(n int) ([]*fakeEndpoint, []string, func()) {
+	var fakes []*fakeEndpoint
+	var urls []string
+	var servers []*httptest.Server
+	for i := 0; i < n; i++ {
+		f := &fakeEndpoint{}
+		ts := httptest.NewServer(f)
+		fakes = append(fakes, f)
+		urls = append(urls, ts.URL)
+		servers = append(servers, ts)
+	}
+	return fakes, urls, func() {
+		for _, ts := range servers {
+			ts.Close()
+		}
+	}
+}
+
+func TestParseBalance(t *testing.T) {
+	for s, expect := range map[string]Balance{"": RoundRobin, "round-robin": RoundRobin, "least-latency": LeastLatency} {
+		b, err := ParseBalance(s)
+		if err != nil || b != expect {
+			t.Errorf("ParseBalance(%q): expected %v, got %v, %v", s, expect, b, err)
+		}
+	}
+	_, err := ParseBalance("random")
+	if err == nil {
+		t.Error("expected error from ParseBalance() but got nil")
+	}
+}
+
+func TestSetURLs(t *testing.T) {
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}}
+
+	t.Run("round robin",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			for i := 0; i < 4; i++ {
+				err := obj.SendMessage(logEvent)
+				if err != nil {
+					t.Fatalf("SendMessage() returned unexpected error %v", err)
+				}
+			}
+			if fakes[0].count() != 2 || fakes[1].count() != 2 {
+				t.Errorf("expected 2 events each, got %d and %d", fakes[0].count(), fakes[1].count())
+			}
+		},
+	)
+
+	t.Run("least latency",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			fakes[0].delay = 20 * time.Millisecond
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetBalance(LeastLatency)
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			for i := 0; i < 5; i++ {
+				obj.SendMessage(logEvent)
+			}
+			if fakes[0].count() != 1 || fakes[1].count() != 4 {
+				t.Errorf("expected 1 and 4 events, got %d and %d", fakes[0].count(), fakes[1].count())
+			}
+		},
+	)
+
+	t.Run("busy URL out of rotation",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			fakes[0].setBusy(true)
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			for i := 0; i < 3; i++ {
+				err := obj.SendMessage(logEvent)
+				if err != nil {
+					t.Fatalf("SendMessage() returned unexpected error %v", err)
+				}
+			}
+			if fakes[1].count() != 3 {
+				t.Errorf("expected 3 events on the second URL, got %d", fakes[1].count())
+			}
+			status := obj.Endpoints()
+			if status[0].Healthy || status[0].Failures != 1 || status[0].LastError != "HEC returned HTTP 503: Server is busy (code 9)" {
+				t.Errorf("expected first URL out of rotation after 1 failure, got %+v", status[0])
+			}
+			if !status[1].Healthy {
+				t.Errorf("expected second URL healthy, got %+v", status[1])
+			}
+		},
+	)
+
+	t.Run("unencodable event keeps URL in rotation",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			nan := logevent.LogEvent{Content: logevent.MessageContent{
+				Metric: &logevent.Metric{Values: map[string]float64{"m": math.NaN()}},
+			}}
+			err := obj.SendMessage(nan)
+			if err == nil {
+				t.Fatal("expected error from SendMessage() but got nil")
+			}
+			for _, status := range obj.Endpoints() {
+				if !status.Healthy || status.Failures != 0 {
+					t.Errorf("expected URL in rotation, got %+v", status)
+				}
+			}
+			if fakes[0].count()+fakes[1].count() != 0 {
+				t.Errorf("expected no requests, got %d and %d", fakes[0].count(), fakes[1].count())
+			}
+		},
+	)
+
+	t.Run("rejected event leaves URL status unchanged",
+		func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(rejectingHEC))
+			defer ts.Close()
+			obj := New("unused", "t")
+			obj.SetURLs([]string{ts.URL})
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logevent.LogEvent{Content: logevent.MessageContent{Event: "bad"}})
+			if err == nil {
+				t.Fatal("expected error from SendMessage() but got nil")
+			}
+			status := obj.Endpoints()[0]
+			if !status.Healthy || status.Failures != 0 || status.Latency != 0 {
+				t.Errorf("expected URL status unchanged by a rejected event, got %+v", status)
+			}
+		},
+	)
+
+	t.Run("unreachable URL",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(1)
+			defer cleanup()
+			down := httptest.NewServer(http.NotFoundHandler())
+			down.Close()
+			obj := New("unused", "t")
+			obj.SetURLs([]string{down.URL, urls[0]})
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err != nil {
+				t.Fatalf("SendMessage() returned unexpected error %v", err)
+			}
+			if fakes[0].count() != 1 {
+				t.Errorf("expected event on the reachable URL, got %d", fakes[0].count())
+			}
+		},
+	)
+
+	t.Run("all busy",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			fakes[0].setBusy(true)
+			fakes[1].setBusy(true)
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetHealthCheck(0)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+		},
+	)
+
+	t.Run("health check returns URL to rotation",
+		func(t *testing.T) {
+			fakes, urls, cleanup := newFakeEndpoints(2)
+			defer cleanup()
+			fakes[0].setBusy(true)
+			obj := New("unused", "t")
+			obj.SetURLs(urls)
+			obj.SetHealthCheck(time.Millisecond)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			waitFor := func(healthy bool) {
+				deadline := time.Now().Add(time.Second)
+				for obj.Endpoints()[0].Healthy != healthy {
+					if time.Now().After(deadline) {
+						t.Fatalf("expected first URL healthy=%t, got %+v", healthy, obj.Endpoints()[0])
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+			waitFor(false)
+			fakes[0].setBusy(false)
+			waitFor(true)
+		},
+	)
+}
@@ -0,0 +1,109 @@
+package sendhec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fuyufjh/splunk-hec-go" // hec
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Error is a response from HEC other than success, as returned by SendMessage, SendBatch and Probe.
+type Error struct {
+	URL        string // HEC URL the request was sent to
+	StatusCode int    // HTTP status
+	Code       int    // HEC status code, such as 4 (invalid token), 6 (invalid data format) or 9 (server busy); -1 when the response has none
+	Text       string // HEC message, such as "Invalid data format"
+	Event      int    // index of the rejected LogEvent in the SendBatch call (0 for SendMessage); -1 when HEC did not say
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("HEC returned HTTP %d: %s", e.StatusCode, e.Text)
+	if e.Code >= 0 {
+		msg += fmt.Sprintf(" (code %d)", e.Code)
+	}
+	if e.Event >= 0 {
+		msg += fmt.Sprintf(" at event %d", e.Event)
+	}
+	return msg
+}
+
+// Retryable returns true when the request may succeed if sent again, perhaps to another HEC URL:
+// when HEC reports an internal error or that it is busy, or, without a known HEC error code,
+// for HTTP 429 or 5xx, such as from a load balancer. Rejected tokens and events are not retryable.
+func (e *Error) Retryable() bool {
+	switch {
+	case e.Code == hec.StatusInternalServerError || e.Code == hec.StatusServerBusy:
+		return true
+	case e.Code > hec.StatusSuccess && e.Code <= hec.StatusAckDisabled:
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// isAuthError returns true when HEC rejected a request because of its token.
+func isAuthError(err error) bool {
+	var hecErr *Error
+	if !errors.As(err, &hecErr) {
+		return false
+	}
+	switch hecErr.Code {
+	case hec.StatusTokenDisabled, hec.StatusTokenRequired, hec.StatusInvalidAuthorization, hec.StatusInvalidToken:
+		return true
+	}
+	return false
+}
+
+// reply is the body of a HEC response.
+type reply struct {
+	AckID              *int            `json:"ackId"`
+	Acks               map[string]bool `json:"acks"`
+	Code               *int            `json:"code"`
+	InvalidEventNumber *int            `json:"invalid-event-number"`
+	Text               string          `json:"text"`
+}
+
+// readReply reads the reply to a request to hecURL.
+// A response other than HTTP 200, or one which is not from HEC, is returned as an *Error
+// whose Event is HEC's invalid-event-number: the index of the rejected event in the request.
+func readReply(hecURL string, res *http.Response) (*reply, error) {
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var r reply
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, &Error{
+			URL:        hecURL,
+			StatusCode: res.StatusCode,
+			Code:       -1,
+			Text:       fmt.Sprintf("unexpected body %q", data),
+			Event:      -1,
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		hecErr := &Error{URL: hecURL, StatusCode: res.StatusCode, Code: -1, Text: r.Text, Event: -1}
+		if r.Code != nil {
+			hecErr.Code = *r.Code
+		}
+		if r.InvalidEventNumber != nil {
+			hecErr.Event = *r.InvalidEventNumber
+		}
+		return nil, hecErr
+	}
+	return &r, nil
+}
+
+// mapEvent translates the Event of a HEC error from an index into a request to an index
+// into the caller's events, given the caller's index of each event in the request.
+func mapEvent(err error, indexes []int) {
+	var hecErr *Error
+	if !errors.As(err, &hecErr) || hecErr.Event < 0 {
+		return
+	}
+	if hecErr.Event < len(indexes) {
+		hecErr.Event = indexes[hecErr.Event]
+	} else {
+		hecErr.Event = -1
+	}
+}
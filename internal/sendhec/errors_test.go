@@ -0,0 +1,174 @@
+package sendhec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// rejectingHEC rejects a request containing the event "bad" as HEC does,
+// with the invalid-event-number of the first such event in the request.
+func rejectingHEC(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	for n := 0; ; n++ {
+		var event struct {
+			Event interface{} `json:"event"`
+		}
+		err := decoder.Decode(&event)
+		if err == io.EOF {
+			break
+		}
+		if event.Event == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"text":"Invalid data format","code":6,"invalid-event-number":%d}`, n)
+			return
+		}
+	}
+	w.Write([]byte(`{"text":"Success","code":0}`))
+}
+
+func TestError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(rejectingHEC))
+	defer ts.Close()
+	obj := New(ts.URL, "t")
+	obj.OpenSvc()
+	defer obj.CloseSvc()
+	event := func(s string) logevent.LogEvent {
+		return logevent.LogEvent{Content: logevent.MessageContent{Event: s}}
+	}
+
+	t.Run("SendBatch",
+		func(t *testing.T) {
+			// the empty event is not sent, so "bad" is event 2 of the request
+			err := obj.SendBatch([]logevent.LogEvent{event("a"), event(""), event("b"), event("bad")})
+			hecErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("expected *Error, got %T %v", err, err)
+			}
+			expected := Error{URL: ts.URL, StatusCode: 400, Code: 6, Text: "Invalid data format", Event: 3}
+			if *hecErr != expected {
+				t.Errorf("expected %+v, got %+v", expected, *hecErr)
+			}
+			if hecErr.Retryable() {
+				t.Error("expected invalid data format not to be retryable")
+			}
+			message := "HEC returned HTTP 400: Invalid data format (code 6) at event 3"
+			if hecErr.Error() != message {
+				t.Errorf("expected %q, got %q", message, hecErr.Error())
+			}
+		},
+	)
+
+	t.Run("SendMessage",
+		func(t *testing.T) {
+			err := obj.SendMessage(event("bad"))
+			hecErr, ok := err.(*Error)
+			if !ok || hecErr.Event != 0 {
+				t.Errorf("expected *Error for event 0, got %v", err)
+			}
+		},
+	)
+
+	t.Run("JSON without a code",
+		func(t *testing.T) {
+			busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"text":"slow down"}`))
+			}))
+			defer busy.Close()
+			obj := New(busy.URL, "t")
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(event("a"))
+			hecErr, ok := err.(*Error)
+			if !ok || hecErr.Code != -1 || !hecErr.Retryable() {
+				t.Errorf("expected retryable *Error without a HEC code, got %v", err)
+			}
+		},
+	)
+
+	t.Run("not from HEC",
+		func(t *testing.T) {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte("<html>Bad Gateway</html>"))
+			}))
+			defer proxy.Close()
+			obj := New(proxy.URL, "t")
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(event("a"))
+			hecErr, ok := err.(*Error)
+			if !ok || hecErr.StatusCode != 502 || hecErr.Code != -1 || hecErr.Event != -1 {
+				t.Fatalf("expected *Error for HTTP 502 without a HEC code, got %v", err)
+			}
+			if !hecErr.Retryable() {
+				t.Error("expected HTTP 502 to be retryable")
+			}
+		},
+	)
+}
+
+func TestError_Retryable(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		code       int
+		expect     bool
+	}{
+		{400, 6, false},
+		{403, 4, false},
+		{500, 8, true},
+		{503, 9, true},
+		{429, -1, true},
+		{502, -1, true},
+		{404, -1, false},
+		{503, 0, true},
+		{429, 17, true},
+		{400, 0, false},
+	}
+	for _, test := range tests {
+		e := &Error{StatusCode: test.statusCode, Code: test.code, Event: -1}
+		if e.Retryable() != test.expect {
+			t.Errorf("HTTP %d code %d: expected Retryable() %t, got %t", test.statusCode, test.code, test.expect, e.Retryable())
+		}
+	}
+}
+
+func TestWrappedError(t *testing.T) {
+	wrap := func(e *Error) error {
+		return fmt.Errorf("%w; token refresh failed", e)
+	}
+
+	t.Run("isAuthError",
+		func(t *testing.T) {
+			if !isAuthError(wrap(&Error{StatusCode: 403, Code: 4, Event: -1})) {
+				t.Error("expected wrapped invalid token to be an auth error")
+			}
+		},
+	)
+
+	t.Run("isEndpointError",
+		func(t *testing.T) {
+			if !isEndpointError(wrap(&Error{StatusCode: 503, Code: 9, Event: -1})) {
+				t.Error("expected wrapped server busy to be an endpoint error")
+			}
+			if isEndpointError(wrap(&Error{StatusCode: 400, Code: 6, Event: 0})) {
+				t.Error("expected wrapped invalid data format not to be an endpoint error")
+			}
+		},
+	)
+
+	t.Run("mapEvent",
+		func(t *testing.T) {
+			hecErr := &Error{StatusCode: 400, Code: 6, Event: 1}
+			mapEvent(wrap(hecErr), []int{0, 2})
+			if hecErr.Event != 2 {
+				t.Errorf("expected Event 2, got %d", hecErr.Event)
+			}
+		},
+	)
+}
@@ -0,0 +1,71 @@
+package sendhec
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPSettings configures the connections of a session to its HEC URLs.
+// A zero timeout means no limit.
+type HTTPSettings struct {
+	ConnectTimeout   time.Duration // to establish a TCP connection
+	TLSTimeout       time.Duration // for the TLS handshake
+	ResponseTimeout  time.Duration // from sending a request until the response headers arrive
+	RequestTimeout   time.Duration // for the whole request: connecting, sending it and reading the response
+	MaxIdleConns     int           // idle connections kept open for reuse, per HEC URL; 0 is Go's default of 2
+	IdleTimeout      time.Duration // how long an idle connection is kept open
+	DisableKeepAlive bool          // close each connection after one request (HTTP keep-alive; TCP keep-alive probes remain)
+	DisableHTTP2     bool          // use HTTP/1.1 even when the server offers HTTP/2
+}
+
+// DefaultHTTPSettings returns the settings used unless SetHTTP is called:
+// timeouts of 10s to connect, 10s for the TLS handshake, 30s for a response and 60s
+// for the whole request, and up to 10 idle connections per HEC URL, kept for 90s.
+func DefaultHTTPSettings() HTTPSettings {
+	return HTTPSettings{
+		ConnectTimeout:  10 * time.Second,
+		TLSTimeout:      10 * time.Second,
+		ResponseTimeout: 30 * time.Second,
+		RequestTimeout:  60 * time.Second,
+		MaxIdleConns:    10,
+		IdleTimeout:     90 * time.Second,
+	}
+}
+
+// SetHTTP sets the timeouts and connection reuse of the session (see DefaultHTTPSettings).
+// A request which times out fails like one whose HEC URL cannot be reached.
+func (sender *Sess) SetHTTP(s HTTPSettings) {
+	sender.http = s
+}
+
+// newHTTPClient returns a client with the session's HTTP, proxy and TLS settings.
+func (sender *Sess) newHTTPClient() *http.Client {
+	s := sender.http
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// TCP keep-alive probes detect dead peers whether or not connections are reused
+	transport.DialContext = (&net.Dialer{Timeout: s.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.DisableKeepAlives = s.DisableKeepAlive
+	transport.IdleConnTimeout = s.IdleTimeout
+	transport.MaxIdleConnsPerHost = s.MaxIdleConns
+	transport.ResponseHeaderTimeout = s.ResponseTimeout
+	transport.TLSHandshakeTimeout = s.TLSTimeout
+	if s.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if sender.proxy != nil {
+		transport.Proxy = sender.proxy.HTTP
+	}
+	if sender.tlsConfig != nil {
+		transport.TLSClientConfig = sender.tlsConfig.Clone()
+	}
+	if sender.hecInsecure {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	return &http.Client{Timeout: s.RequestTimeout, Transport: transport}
+}
@@ -0,0 +1,161 @@
+package sendhec
+
+import (
+	"github.com/djschaap/logevent"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetHTTP(t *testing.T) {
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}}
+
+	t.Run("response timeout",
+		func(t *testing.T) {
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))
+			defer ts.Close()
+			defer close(release)
+			obj := New(ts.URL, "t")
+			settings := DefaultHTTPSettings()
+			settings.ResponseTimeout = 50 * time.Millisecond
+			obj.SetHTTP(settings)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			start := time.Now()
+			err := obj.SendMessage(logEvent)
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected SendMessage() to time out after 50ms, took %v", elapsed)
+			}
+		},
+	)
+
+	t.Run("request timeout",
+		func(t *testing.T) {
+			// sends the response headers, then stalls before the body
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				<-release
+			}))
+			defer ts.Close()
+			defer close(release)
+			obj := New(ts.URL, "t")
+			settings := DefaultHTTPSettings()
+			settings.RequestTimeout = 50 * time.Millisecond
+			obj.SetHTTP(settings)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			start := time.Now()
+			err := obj.SendMessage(logEvent)
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected SendMessage() to time out after 50ms, took %v", elapsed)
+			}
+		},
+	)
+
+	t.Run("TLS handshake timeout",
+		func(t *testing.T) {
+			// accepts connections but never answers the TLS handshake
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			var conns []net.Conn
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					conns = append(conns, conn)
+				}
+			}()
+			obj := New("https://"+listener.Addr().String(), "t")
+			settings := DefaultHTTPSettings()
+			settings.TLSTimeout = 50 * time.Millisecond
+			obj.SetHTTP(settings)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			start := time.Now()
+			err = obj.SendMessage(logEvent)
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected SendMessage() to time out after 50ms, took %v", elapsed)
+			}
+		},
+	)
+
+	for _, disable := range []bool{false, true} {
+		expected := 1
+		name := "keep-alive reuses a connection"
+		if disable {
+			expected = 3
+			name = "keep-alive disabled"
+		}
+		t.Run(name,
+			func(t *testing.T) {
+				var mtx sync.Mutex
+				conns := 0
+				ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"text":"Success","code":0}`))
+				}))
+				ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+					if state == http.StateNew {
+						mtx.Lock()
+						conns++
+						mtx.Unlock()
+					}
+				}
+				ts.Start()
+				defer ts.Close()
+				obj := New(ts.URL, "t")
+				settings := DefaultHTTPSettings()
+				settings.DisableKeepAlive = disable
+				obj.SetHTTP(settings)
+				obj.OpenSvc()
+				defer obj.CloseSvc()
+				for i := 0; i < 3; i++ {
+					err := obj.SendMessage(logEvent)
+					if err != nil {
+						t.Fatalf("SendMessage() returned unexpected error %v", err)
+					}
+				}
+				mtx.Lock()
+				defer mtx.Unlock()
+				if conns != expected {
+					t.Errorf("expected %d connection(s), got %d", expected, conns)
+				}
+			},
+		)
+	}
+
+	t.Run("HTTP/2 disabled",
+		func(t *testing.T) {
+			obj := New("https://localhost:8088", "t")
+			settings := DefaultHTTPSettings()
+			settings.DisableHTTP2 = true
+			obj.SetHTTP(settings)
+			transport := obj.newHTTPClient().Transport.(*http.Transport)
+			if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil {
+				t.Errorf("expected HTTP/2 disabled, got ForceAttemptHTTP2=%t TLSNextProto=%v",
+					transport.ForceAttemptHTTP2, transport.TLSNextProto)
+			}
+		},
+	)
+}
@@ -0,0 +1,122 @@
+package sendhec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"net/url"
+)
+
+// rawMetadata is the metadata of a request to the raw endpoint, which applies to all of its events.
+type rawMetadata struct {
+	host       string
+	index      string
+	source     string
+	sourcetype string
+}
+
+// SetRaw sends all LogEvents, except metrics, to the raw endpoint (/services/collector/raw),
+// so Splunk applies line breaking and timestamp extraction to them.
+// Otherwise, only LogEvents with Content.Raw set are sent to the raw endpoint.
+func (sender *Sess) SetRaw(v bool) {
+	sender.raw = v
+}
+
+// isRaw returns true when logEvent is sent to the raw endpoint.
+// Metrics are always sent to the event endpoint.
+func (sender *Sess) isRaw(logEvent logevent.LogEvent) bool {
+	return logEvent.Content.Metric == nil && (sender.raw || logEvent.Content.Raw)
+}
+
+func (sender *Sess) rawMetadata(logEvent logevent.LogEvent) rawMetadata {
+	m := rawMetadata{
+		host:       logEvent.Content.Host,
+		index:      logEvent.Content.Index,
+		source:     logEvent.Content.Source,
+		sourcetype: logEvent.Content.Sourcetype,
+	}
+	if m.index == "" {
+		m.index = sender.defaultIndex
+	}
+	return m
+}
+
+// endpoint returns the raw endpoint, with m as its query.
+func (m rawMetadata) endpoint() string {
+	query := url.Values{}
+	for k, v := range map[string]string{
+		"host":       m.host,
+		"index":      m.index,
+		"source":     m.source,
+		"sourcetype": m.sourcetype,
+	} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if len(query) == 0 {
+		return "/services/collector/raw"
+	}
+	return "/services/collector/raw?" + query.Encode()
+}
+
+// rawText returns the text of a LogEvent for the raw endpoint:
+// Content.Event itself when it is a string, otherwise Content.Event as JSON.
+func rawText(logEvent logevent.LogEvent) (string, error) {
+	switch event := logEvent.Content.Event.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return event, nil
+	case []byte:
+		return string(event), nil
+	}
+	data, err := json.Marshal(logEvent.Content.Event)
+	if err != nil {
+		return "", fmt.Errorf("unable to format event for the raw endpoint: %v", err)
+	}
+	return string(data), nil
+}
+
+// formatRaw returns the body of a raw endpoint request: the text of each LogEvent,
+// newline-delimited, and the number of events. Empty events are skipped.
+// Content.Time and Content.Fields are not sent; Splunk extracts the timestamp.
+func formatRaw(logEvents []logevent.LogEvent) ([]byte, int, error) {
+	var body bytes.Buffer
+	count := 0
+	for _, logEvent := range logEvents {
+		text, err := rawText(logEvent)
+		if err != nil {
+			return nil, 0, err
+		}
+		if text == "" {
+			continue
+		}
+		body.WriteString(text)
+		body.WriteByte('\n')
+		count++
+	}
+	return body.Bytes(), count, nil
+}
+
+// sendRaw sends logEvents, which share metadata m, in one request to the raw endpoint of hecURL,
+// waiting for indexer acknowledgment when it is enabled.
+func (sender *Sess) sendRaw(hecURL string, m rawMetadata, logEvents []logevent.LogEvent) error {
+	body, count, err := formatRaw(logEvents)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+	sender.tracePretty("TRACE_SENDHEC raw batch of", count,
+		" endpoint =", m.endpoint(), " body =", string(body))
+	if sender.ackTimeout > 0 {
+		err = sender.postWithAck(hecURL, m.endpoint(), body, count)
+	} else {
+		_, err = sender.postHEC(hecURL, m.endpoint(), body)
+	}
+	mapEvent(err, nil) // the raw endpoint has no events to number, only lines
+	return err
+}
@@ -0,0 +1,134 @@
+package sendhec
+
+import (
+	"github.com/djschaap/logevent"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRawHEC records each request as its URL (path and query) and body.
+type fakeRawHEC struct {
+	mtx      sync.Mutex
+	requests [][2]string
+}
+
+func newFakeRawHEC() (*fakeRawHEC, *httptest.Server) {
+	f := &fakeRawHEC{}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeRawHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	target := r.URL.Path
+	if r.URL.Path == "/services/collector/raw" && r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	f.requests = append(f.requests, [2]string{target, string(body)})
+	w.Write([]byte(`{"text":"Success","code":0}`))
+}
+
+func TestRender_raw(t *testing.T) {
+	obj := New("https://localhost:8088", "t")
+	obj.SetDefaultIndex("main")
+	payload, err := obj.Render(logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Event:      "line one",
+			Raw:        true,
+			Sourcetype: "access combined",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if expect := "POST https://localhost:8088/services/collector/raw?index=main&sourcetype=access+combined"; payload.Target != expect {
+		t.Errorf("expected Target=%s, got %s", expect, payload.Target)
+	}
+	if expect := "line one\n"; string(payload.Body) != expect {
+		t.Errorf("expected Body=%q, got %q", expect, payload.Body)
+	}
+}
+
+func TestSetRaw(t *testing.T) {
+	t.Run("batched by metadata",
+		func(t *testing.T) {
+			f, ts := newFakeRawHEC()
+			defer ts.Close()
+			obj := New(ts.URL, "t")
+			obj.SetRaw(true)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendBatch([]logevent.LogEvent{
+				{Content: logevent.MessageContent{Event: "a", Host: "h1"}},
+				{Content: logevent.MessageContent{Event: "b", Host: "h1", Time: time.Now()}},
+				{Content: logevent.MessageContent{Host: "h1"}},
+				{Content: logevent.MessageContent{Event: map[string]interface{}{"k": "v"}, Host: "h2"}},
+			})
+			if err != nil {
+				t.Fatalf("SendBatch() returned unexpected error %v", err)
+			}
+			expect := [][2]string{
+				{"/services/collector/raw?host=h1", "a\nb\n"},
+				{"/services/collector/raw?host=h2", "{\"k\":\"v\"}\n"},
+			}
+			if len(f.requests) != len(expect) {
+				t.Fatalf("expected %q, got %q", expect, f.requests)
+			}
+			for i := range expect {
+				if f.requests[i] != expect[i] {
+					t.Errorf("expected request %d %q, got %q", i, expect[i], f.requests[i])
+				}
+			}
+		},
+	)
+
+	t.Run("per event, in order",
+		func(t *testing.T) {
+			f, ts := newFakeRawHEC()
+			defer ts.Close()
+			obj := New(ts.URL, "t")
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendBatch([]logevent.LogEvent{
+				{Content: logevent.MessageContent{Event: "json 1"}},
+				{Content: logevent.MessageContent{Event: "raw", Raw: true}},
+				{Content: logevent.MessageContent{Event: "json 2"}},
+			})
+			if err != nil {
+				t.Fatalf("SendBatch() returned unexpected error %v", err)
+			}
+			expect := []string{"/services/collector", "/services/collector/raw", "/services/collector"}
+			if len(f.requests) != len(expect) {
+				t.Fatalf("expected requests to %q, got %q", expect, f.requests)
+			}
+			for i := range expect {
+				if f.requests[i][0] != expect[i] {
+					t.Errorf("expected request %d to %s, got %s", i, expect[i], f.requests[i][0])
+				}
+			}
+		},
+	)
+
+	t.Run("with acknowledgment",
+		func(t *testing.T) {
+			f, ts := newFakeAckHEC(1)
+			defer ts.Close()
+			obj := newAckSess(ts.URL, time.Second, 0)
+			obj.SetRaw(true)
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}})
+			if err != nil {
+				t.Fatalf("SendMessage() returned unexpected error %v", err)
+			}
+			if len(f.requests) != 1 || f.requests[0] != "e\n" || f.polls[0] != 2 {
+				t.Errorf("expected 1 request acknowledged after 2 polls, got %q and %v", f.requests, f.polls)
+			}
+		},
+	)
+}
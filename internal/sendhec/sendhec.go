@@ -1,67 +1,225 @@
 package sendhec
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/credential"
+	"github.com/djschaap/logevent/internal/proxy"
 	"github.com/fuyufjh/splunk-hec-go" // hec
+	"github.com/google/uuid"
 	"github.com/kr/pretty"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
+// probeTimeout limits how long Probe waits for a response.
+const probeTimeout = 10 * time.Second
+
 // Sess stores sendhec session state.
 type Sess struct {
-	hecClient   hec.HEC
-	hecInsecure bool
-	hecToken    string
-	hecURL      string
-	trace       bool
+	ackPollInterval time.Duration
+	ackResends      int
+	ackTimeout      time.Duration
+	balance         Balance
+	channel         string
+	defaultIndex    string
+	endpointMtx     sync.Mutex
+	endpoints       []*endpoint // one for each of hecURLs while the session is open
+	healthDone      chan struct{}
+	healthInterval  time.Duration
+	healthStop      chan struct{}
+	hecInsecure     bool
+	hecToken        string
+	hecURL          string // the first of hecURLs
+	hecURLs         []string
+	http            HTTPSettings
+	httpClient      *http.Client // shared by all requests while the session is open
+	next            int          // index of the next endpoint, for RoundRobin
+	proxy           proxy.Func
+	raw             bool
+	tlsConfig       *tls.Config
+	tokenProvider   credential.Provider
+	trace           bool
 }
 
 // CloseSvc closes the open session.
 // CloseSvc must not be called when no session is open.
 func (sender *Sess) CloseSvc() error {
-	if sender.hecClient == nil {
+	if sender.endpoints == nil {
 		return errors.New("CloseSvc() called again or before OpenSvc(); that should not be done")
 	}
-	sender.hecClient = nil
+	sender.closeEndpoints()
+	sender.httpClient.CloseIdleConnections()
+	sender.httpClient = nil
 	return nil
 }
 
 // OpenSvc opens a new session.
 // OpenSvc must not be called when a session is already open.
 func (sender *Sess) OpenSvc() error {
-	if sender.hecClient != nil {
+	if sender.endpoints != nil {
 		return errors.New("OpenSvc() called again; that should not be done")
 	}
-	client := hec.NewCluster(
-		[]string{sender.hecURL},
-		sender.hecToken,
-	)
-	if sender.hecInsecure {
-		client.SetHTTPClient(&http.Client{Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}})
+	if sender.tokenProvider != nil {
+		_, err := sender.refreshToken()
+		if err != nil {
+			return err
+		}
+	}
+	sender.channel = uuid.New().String()
+	sender.httpClient = sender.newHTTPClient()
+	sender.openEndpoints()
+	return nil
+}
+
+// Probe posts an empty request to the HEC event endpoint of each HEC URL, which a collector
+// answers with "No data" when the token is valid.
+func (sender *Sess) Probe() error {
+	if sender.tokenProvider != nil {
+		_, err := sender.refreshToken()
+		if err != nil {
+			return err
+		}
+	}
+	if len(sender.hecURLs) == 1 {
+		return sender.probeURL(sender.hecURL)
+	}
+	var failed []string
+	for _, hecURL := range sender.hecURLs {
+		err := sender.probeURL(hecURL)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", hecURL, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d HEC URLs failed: %s", len(failed), len(sender.hecURLs), strings.Join(failed, "; "))
 	}
-	sender.hecClient = client
 	return nil
 }
 
+func (sender *Sess) probeURL(hecURL string) error {
+	req, err := http.NewRequest(http.MethodPost, hecURL+"/services/collector/event", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Splunk "+sender.hecToken)
+	client := sender.newHTTPClient()
+	client.Timeout = probeTimeout
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = readReply(hecURL, res)
+	var hecErr *Error
+	if errors.As(err, &hecErr) && hecErr.Code == hec.StatusNoData {
+		return nil
+	}
+	return err
+}
+
+// Render returns the request SendMessage would make for a LogEvent, with the HEC token masked.
+// The headers are those of the real request; the X-Splunk-Request-Channel header is a placeholder
+// until the session is opened.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	if sender.isRaw(logEvent) {
+		body, _, err := formatRaw([]logevent.LogEvent{logEvent})
+		if err != nil {
+			return logevent.Payload{}, err
+		}
+		payload := logevent.Payload{
+			Body:    body,
+			Headers: sender.requestHeaders(credential.Mask(sender.hecToken)),
+			Target:  "POST " + sender.hecURL + sender.rawMetadata(logEvent).endpoint(),
+		}
+		return payload, nil
+	}
+	body, err := json.Marshal(sender.formatLogEvent(logEvent))
+	if err != nil {
+		return logevent.Payload{}, err
+	}
+	payload := logevent.Payload{
+		Body:    body,
+		Headers: sender.requestHeaders(credential.Mask(sender.hecToken)),
+		Target:  "POST " + sender.hecURL + "/services/collector",
+	}
+	return payload, nil
+}
+
+// SendBatch sends several LogEvents to a Splunk HTTP Event Collector in one request.
+// LogEvents for the raw endpoint are sent, in order, in one request for each run
+// of consecutive LogEvents with the same host, index, source and sourcetype.
+func (sender *Sess) SendBatch(logEvents []logevent.LogEvent) error {
+	if sender.endpoints == nil {
+		return errors.New("SendBatch() called before OpenSvc()")
+	}
+	hecEvents := make([]*hec.Event, 0, len(logEvents))
+	positions := make([]int, 0, len(logEvents)) // index in logEvents of each of hecEvents
+	writeEvents := func() error {
+		if len(hecEvents) == 0 {
+			return nil
+		}
+		sender.tracePretty("TRACE_SENDHEC batch of", len(hecEvents),
+			" hecEvents =", hecEvents)
+		err := sender.writeBatch(hecEvents)
+		mapEvent(err, positions)
+		hecEvents = hecEvents[:0]
+		positions = positions[:0]
+		return err
+	}
+	for i := 0; i < len(logEvents); {
+		if !sender.isRaw(logEvents[i]) {
+			hecEvents = append(hecEvents, sender.formatLogEvent(logEvents[i]))
+			positions = append(positions, i)
+			i++
+			continue
+		}
+		err := writeEvents()
+		if err != nil {
+			return err
+		}
+		m := sender.rawMetadata(logEvents[i])
+		j := i + 1
+		for j < len(logEvents) && sender.isRaw(logEvents[j]) && sender.rawMetadata(logEvents[j]) == m {
+			j++
+		}
+		err = sender.writeRaw(m, logEvents[i:j])
+		if err != nil {
+			return err
+		}
+		i = j
+	}
+	return writeEvents()
+}
+
 // SendMessage sends a LogEvent to a Splunk HTTP Event Collector.
 func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
-	if sender.hecClient == nil {
+	if sender.endpoints == nil {
 		return errors.New("SendMessage() called before OpenSvc()")
 	}
+	if sender.isRaw(logEvent) {
+		return sender.writeRaw(sender.rawMetadata(logEvent), []logevent.LogEvent{logEvent})
+	}
 	hecEvents := []*hec.Event{
 		sender.formatLogEvent(logEvent),
 	}
 	sender.tracePretty("TRACE_SENDHEC time =",
 		logEvent.Content.Time.UTC().Format(time.RFC3339),
 		" hecEvents =", hecEvents)
-	err := sender.hecClient.WriteBatch(hecEvents)
-	return err
+	return sender.writeBatch(hecEvents)
+}
+
+// SetDefaultIndex sets the index for LogEvents which do not specify one.
+// Otherwise, HEC uses the token's default index.
+func (sender *Sess) SetDefaultIndex(index string) {
+	sender.defaultIndex = index
 }
 
 // SetHecInsecure disables SSL/TLS validation.
@@ -70,6 +228,24 @@ func (sender *Sess) SetHecInsecure(v bool) {
 	sender.hecInsecure = v
 }
 
+// SetProxy sets the proxy for HEC URLs, in place of HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// (see proxy.Fixed); nil restores them.
+func (sender *Sess) SetProxy(p proxy.Func) {
+	sender.proxy = p
+}
+
+// SetTLSConfig sets the TLS configuration for HEC URLs, such as a CA, client certificate
+// or minimum version (see tlsconfig.Settings). SetHecInsecure still disables validation.
+func (sender *Sess) SetTLSConfig(c *tls.Config) {
+	sender.tlsConfig = c
+}
+
+// SetTokenProvider sets a source for the HEC token which is read when the session is opened,
+// and again when HEC rejects the token, so a rotated token is used without a restart.
+func (sender *Sess) SetTokenProvider(p credential.Provider) {
+	sender.tokenProvider = p
+}
+
 // SetTrace enables tracing, which dumps all messages to stderr.
 func (sender *Sess) SetTrace(v bool) {
 	sender.trace = v
@@ -77,12 +253,18 @@ func (sender *Sess) SetTrace(v bool) {
 
 func (sender *Sess) formatLogEvent(logEvent logevent.LogEvent) *hec.Event {
 	var hecEvent *hec.Event
-	hecEvent = hec.NewEvent(logEvent.Content.Event)
+	if logEvent.Content.Metric != nil {
+		hecEvent = hec.NewEvent("metric")
+	} else {
+		hecEvent = hec.NewEvent(logEvent.Content.Event)
+	}
 	if logEvent.Content.Host != "" {
 		hecEvent.SetHost(logEvent.Content.Host)
 	}
 	if logEvent.Content.Index != "" {
 		hecEvent.SetIndex(logEvent.Content.Index)
+	} else if sender.defaultIndex != "" {
+		hecEvent.SetIndex(sender.defaultIndex)
 	}
 	if logEvent.Content.Source != "" {
 		hecEvent.SetSource(logEvent.Content.Source)
@@ -97,11 +279,114 @@ func (sender *Sess) formatLogEvent(logEvent logevent.LogEvent) *hec.Event {
 		hecEvent.SetTime(logEvent.Content.Time)
 	}
 
-	hecEvent.SetFields(logEvent.Content.Fields)
+	if logEvent.Content.Metric != nil {
+		hecEvent.SetFields(metricFields(logEvent.Content))
+	} else {
+		hecEvent.SetFields(logEvent.Content.Fields)
+	}
 
 	return hecEvent
 }
 
+// metricFields returns the fields of a HEC metric event: Content.Fields and the
+// Metric's dimensions, and a metric_name:NAME field for each of its values.
+func metricFields(content logevent.MessageContent) map[string]interface{} {
+	metric := content.Metric
+	fields := make(map[string]interface{}, len(content.Fields)+len(metric.Dimensions)+len(metric.Values))
+	for k, v := range content.Fields {
+		fields[k] = v
+	}
+	for k, v := range metric.Dimensions {
+		fields[k] = v
+	}
+	for name, value := range metric.Values {
+		fields["metric_name:"+name] = value
+	}
+	return fields
+}
+
+// refreshToken reads the token from tokenProvider, returning true if it changed.
+func (sender *Sess) refreshToken() (bool, error) {
+	token, err := sender.tokenProvider.Credential()
+	if err != nil {
+		return false, fmt.Errorf("unable to read HEC token: %v", err)
+	}
+	changed := token != sender.hecToken
+	sender.hecToken = token
+	return changed, nil
+}
+
+// writeBatch sends hecEvents, trying other HEC URLs and refreshing the token as needed.
+func (sender *Sess) writeBatch(hecEvents []*hec.Event) error {
+	return sender.withTokenRefresh(func() error {
+		return sender.withEndpoint(func(e *endpoint) error {
+			return sender.send(e, hecEvents)
+		})
+	})
+}
+
+// writeRaw sends logEvents to the raw endpoint, trying other HEC URLs and refreshing the token as needed.
+func (sender *Sess) writeRaw(m rawMetadata, logEvents []logevent.LogEvent) error {
+	return sender.withTokenRefresh(func() error {
+		return sender.withEndpoint(func(e *endpoint) error {
+			return sender.sendRaw(e.status.URL, m, logEvents)
+		})
+	})
+}
+
+// withTokenRefresh calls send. When HEC rejects the token and tokenProvider
+// supplies a different one, send is called again with it.
+func (sender *Sess) withTokenRefresh(send func() error) error {
+	err := send()
+	if err == nil || sender.tokenProvider == nil || !isAuthError(err) {
+		return err
+	}
+	changed, refreshErr := sender.refreshToken()
+	if refreshErr != nil {
+		return fmt.Errorf("%w; %v", err, refreshErr)
+	}
+	if !changed {
+		return err
+	}
+	sender.tracePrintln("TRACE_SENDHEC token rejected; retrying with new token")
+	return send()
+}
+
+// send sends hecEvents in one request to the event endpoint of e,
+// waiting for indexer acknowledgment when it is enabled.
+func (sender *Sess) send(e *endpoint, hecEvents []*hec.Event) error {
+	body, indexes, err := formatEvents(hecEvents)
+	if err != nil || len(indexes) == 0 {
+		return err
+	}
+	if sender.ackTimeout > 0 {
+		err = sender.postWithAck(e.status.URL, "/services/collector", body, len(indexes))
+	} else {
+		_, err = sender.postHEC(e.status.URL, "/services/collector", body)
+	}
+	mapEvent(err, indexes)
+	return err
+}
+
+// formatEvents returns the body of a request to the event endpoint for hecEvents,
+// and the index in hecEvents of each event in the body.
+func formatEvents(hecEvents []*hec.Event) ([]byte, []int, error) {
+	var body bytes.Buffer
+	indexes := make([]int, 0, len(hecEvents))
+	for i, hecEvent := range hecEvents {
+		if hecEvent.Event == nil || hecEvent.Event == "" {
+			continue // HEC rejects empty events; skip them, as hec.Client does
+		}
+		data, err := json.Marshal(hecEvent)
+		if err != nil {
+			return nil, nil, err
+		}
+		body.Write(data)
+		indexes = append(indexes, i)
+	}
+	return body.Bytes(), indexes, nil
+}
+
 func (sender *Sess) tracePretty(
 	args ...interface{},
 ) {
@@ -122,8 +407,12 @@ func (sender *Sess) tracePrintln(
 // It requires a Splunk HEC URL and HEC token (typically, GUID).
 func New(hecURL, hecToken string) *Sess {
 	sess := Sess{
-		hecToken: hecToken,
-		hecURL:   hecURL,
+		ackPollInterval: defaultAckPollInterval,
+		healthInterval:  defaultHealthInterval,
+		http:            DefaultHTTPSettings(),
+		hecToken:        hecToken,
+		hecURL:          hecURL,
+		hecURLs:         []string{hecURL},
 	}
 	return &sess
 }
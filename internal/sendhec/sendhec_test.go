@@ -1,9 +1,19 @@
 package sendhec
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/internal/credential"
+	"github.com/djschaap/logevent/internal/proxy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -12,8 +22,8 @@ func TestNew(t *testing.T) {
 	t.Run("with no args",
 		func(t *testing.T) {
 			obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
-			if obj.hecClient != nil {
-				t.Errorf("expected hecClient=nil, got %#v", obj.hecClient)
+			if obj.endpoints != nil {
+				t.Errorf("expected endpoints=nil, got %#v", obj.endpoints)
 			}
 			if obj.trace == true {
 				t.Errorf("expected trace=false, got %s", strconv.FormatBool(obj.trace))
@@ -28,6 +38,63 @@ func TestNew(t *testing.T) {
 	)
 }
 
+func TestProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/collector/event" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Splunk good" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"text":"Invalid token","code":4}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"No data","code":5}`))
+	}))
+	defer ts.Close()
+
+	var _ logevent.Prober = New(ts.URL, "good")
+	err := New(ts.URL, "good").Probe()
+	if err != nil {
+		t.Errorf("Probe() returned unexpected error %v", err)
+	}
+	err = New(ts.URL, "bad").Probe()
+	if err == nil || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("expected Invalid token error from Probe(), got %v", err)
+	}
+	err = New(ts.URL+"/wrong", "good").Probe()
+	if err == nil {
+		t.Error("expected error from Probe() with wrong path but got nil")
+	}
+}
+
+func TestRender(t *testing.T) {
+	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000123")
+	var _ logevent.Renderer = obj
+	payload, err := obj.Render(logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Event: "my event",
+			Host:  "h1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if expect := "POST https://localhost:8088/services/collector"; payload.Target != expect {
+		t.Errorf("expected Target=%s, got %s", expect, payload.Target)
+	}
+	if expect := `{"host":"h1","event":"my event"}`; string(payload.Body) != expect {
+		t.Errorf("expected Body=%s, got %s", expect, payload.Body)
+	}
+	if expect := "Splunk ********************************0123"; payload.Headers["Authorization"] != expect {
+		t.Errorf("expected masked Authorization %s, got %s", expect, payload.Headers["Authorization"])
+	}
+	if expect := "<assigned on OpenSvc>"; payload.Headers["X-Splunk-Request-Channel"] != expect || len(payload.Headers) != 2 {
+		t.Errorf("expected Authorization and X-Splunk-Request-Channel %s headers, got %v", expect, payload.Headers)
+	}
+}
+
 func TestRepeatedOpenAndClose(t *testing.T) {
 	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
 
@@ -52,6 +119,35 @@ func TestRepeatedOpenAndClose(t *testing.T) {
 	}
 }
 
+func TestSendBatch(t *testing.T) {
+	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
+	t.Run("implements BatchSender",
+		func(t *testing.T) {
+			var _ logevent.BatchSender = obj
+		},
+	)
+	t.Run("before OpenSvc",
+		func(t *testing.T) {
+			err := obj.SendBatch([]logevent.LogEvent{{}})
+			if err == nil {
+				t.Error("expected error from SendBatch() but got nil")
+			}
+		},
+	)
+}
+
+func TestSetDefaultIndex(t *testing.T) {
+	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
+	obj.SetDefaultIndex("main")
+	if index := *obj.formatLogEvent(logevent.LogEvent{}).Index; index != "main" {
+		t.Errorf("expected index=main, got %s", index)
+	}
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Index: "mine"}}
+	if index := *obj.formatLogEvent(logEvent).Index; index != "mine" {
+		t.Errorf("expected index=mine, got %s", index)
+	}
+}
+
 func TestSetHecInsecure(t *testing.T) {
 	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
 	if obj.hecInsecure != false {
@@ -65,6 +161,140 @@ func TestSetHecInsecure(t *testing.T) {
 	}
 }
 
+func TestSetProxy(t *testing.T) {
+	// the proxy answers for HEC, so a successful send must have been proxied
+	var proxied []string
+	fakeProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer fakeProxy.Close()
+	proxyURL, _ := url.Parse(fakeProxy.URL)
+
+	obj := New("http://splunk.invalid:8088", "t")
+	obj.SetProxy(proxy.Fixed(proxyURL, "localhost"))
+	obj.OpenSvc()
+	defer obj.CloseSvc()
+	err := obj.SendMessage(logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}})
+	if err != nil {
+		t.Fatalf("SendMessage() returned unexpected error %v", err)
+	}
+	if len(proxied) != 1 || proxied[0] != "splunk.invalid:8088" {
+		t.Errorf("expected 1 request proxied to splunk.invalid:8088, got %v", proxied)
+	}
+}
+
+func TestSetTLSConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}}
+
+	t.Run("unknown CA",
+		func(t *testing.T) {
+			obj := New(ts.URL, "t")
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+		},
+	)
+
+	t.Run("CA from TLS config",
+		func(t *testing.T) {
+			pool := x509.NewCertPool()
+			pool.AddCert(ts.Certificate())
+			obj := New(ts.URL, "t")
+			obj.SetTLSConfig(&tls.Config{RootCAs: pool})
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			if err != nil {
+				t.Errorf("SendMessage() returned unexpected error %v", err)
+			}
+		},
+	)
+}
+
+func TestSetTokenProvider(t *testing.T) {
+	goodToken := "old"
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Splunk "+goodToken {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"text":"Invalid token","code":4}`))
+			return
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	token := "old"
+	logEvent := logevent.LogEvent{Content: logevent.MessageContent{Event: "e"}}
+	obj := New(ts.URL, "")
+	obj.SetTokenProvider(credential.Func(func() (string, error) { return token, nil }))
+	err := obj.OpenSvc()
+	if err != nil {
+		t.Fatalf("OpenSvc() returned unexpected error %v", err)
+	}
+	defer obj.CloseSvc()
+	err = obj.SendMessage(logEvent)
+	if err != nil {
+		t.Errorf("SendMessage() returned unexpected error %v", err)
+	}
+
+	t.Run("rotated token",
+		func(t *testing.T) {
+			goodToken, token, requests = "new", "new", 0
+			err := obj.SendMessage(logEvent)
+			if err != nil {
+				t.Errorf("SendMessage() returned unexpected error %v", err)
+			}
+			if requests != 2 {
+				t.Errorf("expected 2 requests, got %d", requests)
+			}
+		},
+	)
+
+	t.Run("unchanged token",
+		func(t *testing.T) {
+			goodToken, requests = "newer", 0
+			err := obj.SendMessage(logEvent)
+			if err == nil || !strings.Contains(err.Error(), "Invalid token") {
+				t.Errorf("expected Invalid token error, got %v", err)
+			}
+			if requests != 1 {
+				t.Errorf("expected 1 request, got %d", requests)
+			}
+		},
+	)
+
+	t.Run("provider error keeps HEC error",
+		func(t *testing.T) {
+			reads := 0
+			obj := New(ts.URL, "")
+			obj.SetTokenProvider(credential.Func(func() (string, error) {
+				reads++
+				if reads > 1 {
+					return "", errors.New("no file")
+				}
+				return "stale", nil
+			}))
+			obj.OpenSvc()
+			defer obj.CloseSvc()
+			err := obj.SendMessage(logEvent)
+			var hecErr *Error
+			if !errors.As(err, &hecErr) || hecErr.Code != 4 || !strings.Contains(err.Error(), "no file") {
+				t.Errorf("expected wrapped *Error with code 4, got %v", err)
+			}
+		},
+	)
+}
+
 func TestSetTrace(t *testing.T) {
 	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
 	if obj.trace != false {
@@ -119,6 +349,42 @@ func Test_formatLogEvent_empty_LogEvent(t *testing.T) {
 	)
 }
 
+func Test_formatLogEvent_metric(t *testing.T) {
+	logEvent := logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Event:  "ignored",
+			Fields: map[string]interface{}{"team": "ops"},
+			Metric: &logevent.Metric{
+				Dimensions: map[string]string{"region": "us-east-1"},
+				Values:     map[string]float64{"cpu.idle": 95.5, "cpu.user": 0.1},
+			},
+			Raw: true,
+		},
+	}
+	obj := New("https://localhost:8088", "00000000-0000-0000-0000-000000000000")
+	obj.SetRaw(true)
+	hecEvent := obj.formatLogEvent(logEvent)
+	if hecEvent.Event != "metric" {
+		t.Errorf("incorrect Event, expected metric got %#v", hecEvent.Event)
+	}
+	expect := map[string]interface{}{
+		"metric_name:cpu.idle": 95.5,
+		"metric_name:cpu.user": 0.1,
+		"region":               "us-east-1",
+		"team":                 "ops",
+	}
+	if !reflect.DeepEqual(hecEvent.Fields, expect) {
+		t.Errorf("incorrect Fields, expected %#v got %#v", expect, hecEvent.Fields)
+	}
+	payload, err := obj.Render(logEvent)
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if expect := "POST https://localhost:8088/services/collector"; payload.Target != expect {
+		t.Errorf("expected metric sent to %s, got %s", expect, payload.Target)
+	}
+}
+
 func Test_formatLogEvent_simple_LogEvent(t *testing.T) {
 	now := time.Now()
 	logEvent := logevent.LogEvent{
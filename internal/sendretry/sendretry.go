@@ -0,0 +1,143 @@
+package sendretry
+
+import (
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"log"
+	"time"
+)
+
+// Sess stores sendretry session state.
+type Sess struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	sender     logevent.MessageSender
+	sleep      func(time.Duration)
+	trace      bool
+}
+
+// CloseSvc closes the wrapped sender.
+func (sender *Sess) CloseSvc() error {
+	return sender.sender.CloseSvc()
+}
+
+// OpenSvc opens the wrapped sender.
+func (sender *Sess) OpenSvc() error {
+	return sender.sender.OpenSvc()
+}
+
+// Probe probes the wrapped sender, if it supports probing.
+func (sender *Sess) Probe() error {
+	prober, ok := sender.sender.(logevent.Prober)
+	if !ok {
+		return errors.New("wrapped sender does not support Probe")
+	}
+	return prober.Probe()
+}
+
+// Render renders with the wrapped sender, if it supports rendering.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	renderer, ok := sender.sender.(logevent.Renderer)
+	if !ok {
+		return logevent.Payload{}, errors.New("wrapped sender does not support Render")
+	}
+	return renderer.Render(logEvent)
+}
+
+// SendBatch sends several LogEvents, retrying the whole batch on failure.
+// Events are sent one at a time when the wrapped sender is not a BatchSender.
+func (sender *Sess) SendBatch(logEvents []logevent.LogEvent) error {
+	batchSender, ok := sender.sender.(logevent.BatchSender)
+	if !ok {
+		for _, logEvent := range logEvents {
+			err := sender.SendMessage(logEvent)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return sender.retry(func() error {
+		return batchSender.SendBatch(logEvents)
+	})
+}
+
+// SendMessage sends a LogEvent, retrying on failure.
+func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
+	return sender.retry(func() error {
+		return sender.sender.SendMessage(logEvent)
+	})
+}
+
+// SetBackoff sets the delay before the first retry, which doubles after each retry up to max.
+func (sender *Sess) SetBackoff(backoff, max time.Duration) {
+	sender.backoff = backoff
+	sender.maxBackoff = max
+}
+
+// SetTrace enables tracing of retries, and tracing in the wrapped sender.
+func (sender *Sess) SetTrace(v bool) {
+	sender.trace = v
+	sender.sender.SetTrace(v)
+}
+
+// retryable is implemented by errors which know whether sending again may succeed,
+// such as *sendhec.Error, which may be wrapped.
+type retryable interface {
+	Retryable() bool
+}
+
+// retry calls send until it succeeds, up to attempts times.
+// An error which is not Retryable, such as a rejected event, is not retried.
+func (sender *Sess) retry(send func() error) error {
+	delay := sender.backoff
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = send()
+		if err == nil || attempt >= sender.attempts {
+			break
+		}
+		var r retryable
+		if errors.As(err, &r) && !r.Retryable() {
+			break
+		}
+		sender.tracePrintln("TRACE_SENDRETRY attempt", attempt, "failed:", err, "; retrying in", delay)
+		sender.sleep(delay)
+		delay *= 2
+		if sender.maxBackoff > 0 && delay > sender.maxBackoff {
+			delay = sender.maxBackoff
+		}
+	}
+	if err != nil && attempt > 1 {
+		return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+	}
+	return err
+}
+
+func (sender *Sess) tracePrintln(
+	args ...interface{},
+) {
+	if sender.trace {
+		log.Println(args...)
+	}
+}
+
+// New creates a new sendretry object/session.
+// It wraps sender, making up to attempts tries (at least 1) at each send,
+// with a default backoff of 1s doubling to at most 30s.
+func New(sender logevent.MessageSender, attempts int) *Sess {
+	if attempts < 1 {
+		attempts = 1
+	}
+	sess := Sess{
+		attempts:   attempts,
+		backoff:    time.Second,
+		maxBackoff: 30 * time.Second,
+		sender:     sender,
+		sleep:      time.Sleep,
+	}
+	return &sess
+}
@@ -0,0 +1,159 @@
+package sendretry
+
+import (
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	batches  int
+	failures int
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("down")
+	}
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+type rejectingSender struct {
+	fakeSender
+}
+
+func (s *rejectingSender) SendMessage(logevent.LogEvent) error {
+	return fmt.Errorf("%w; token refresh failed", rejected{})
+}
+
+// rejected is an error which is not retryable, as from a sender which rejects an event.
+type rejected struct{}
+
+func (rejected) Error() string   { return "rejected" }
+func (rejected) Retryable() bool { return false }
+
+type fakeBatchSender struct {
+	fakeSender
+}
+
+func (s *fakeBatchSender) SendBatch(logEvents []logevent.LogEvent) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("down")
+	}
+	s.batches++
+	s.received = append(s.received, logEvents...)
+	return nil
+}
+
+func newTestSess(inner logevent.MessageSender, attempts int) (*Sess, *[]time.Duration) {
+	var delays []time.Duration
+	sess := New(inner, attempts)
+	sess.SetBackoff(10*time.Millisecond, 25*time.Millisecond)
+	sess.sleep = func(d time.Duration) { delays = append(delays, d) }
+	return sess, &delays
+}
+
+func TestNew(t *testing.T) {
+	var _ logevent.MessageSender = New(&fakeSender{}, 3)
+	var _ logevent.BatchSender = New(&fakeSender{}, 3)
+	if New(&fakeSender{}, 0).attempts != 1 {
+		t.Error("expected attempts to be at least 1")
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	t.Run("succeeds after retries",
+		func(t *testing.T) {
+			inner := &fakeSender{failures: 3}
+			sess, delays := newTestSess(inner, 4)
+			err := sess.SendMessage(logevent.LogEvent{})
+			if err != nil {
+				t.Errorf("SendMessage() returned unexpected error %v", err)
+			}
+			if len(inner.received) != 1 {
+				t.Errorf("expected 1 event, got %d", len(inner.received))
+			}
+			expect := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}
+			if len(*delays) != len(expect) {
+				t.Fatalf("expected delays %v, got %v", expect, *delays)
+			}
+			for i := range expect {
+				if (*delays)[i] != expect[i] {
+					t.Errorf("expected delays %v, got %v", expect, *delays)
+				}
+			}
+		},
+	)
+
+	t.Run("fails after all attempts",
+		func(t *testing.T) {
+			inner := &fakeSender{failures: 5}
+			sess, _ := newTestSess(inner, 2)
+			err := sess.SendMessage(logevent.LogEvent{})
+			if err == nil {
+				t.Error("expected error from SendMessage() but got nil")
+			}
+			if inner.failures != 3 {
+				t.Errorf("expected 2 attempts, got %d", 5-inner.failures)
+			}
+		},
+	)
+	t.Run("does not retry an error which is not retryable",
+		func(t *testing.T) {
+			sess, delays := newTestSess(&rejectingSender{}, 3)
+			err := sess.SendMessage(logevent.LogEvent{})
+			if !errors.As(err, new(rejected)) {
+				t.Errorf("expected rejected error, got %v", err)
+			}
+			if len(*delays) != 0 {
+				t.Errorf("expected no retries, got delays %v", *delays)
+			}
+		},
+	)
+}
+
+func TestSendBatch(t *testing.T) {
+	t.Run("with BatchSender",
+		func(t *testing.T) {
+			inner := &fakeBatchSender{fakeSender{failures: 1}}
+			sess, _ := newTestSess(inner, 2)
+			err := sess.SendBatch([]logevent.LogEvent{{}, {}})
+			if err != nil {
+				t.Errorf("SendBatch() returned unexpected error %v", err)
+			}
+			if inner.batches != 1 || len(inner.received) != 2 {
+				t.Errorf("expected 1 batch of 2, got %d batches, %d events", inner.batches, len(inner.received))
+			}
+		},
+	)
+
+	t.Run("without BatchSender",
+		func(t *testing.T) {
+			inner := &fakeSender{failures: 1}
+			sess, _ := newTestSess(inner, 2)
+			err := sess.SendBatch([]logevent.LogEvent{{}, {}})
+			if err != nil {
+				t.Errorf("SendBatch() returned unexpected error %v", err)
+			}
+			if len(inner.received) != 2 {
+				t.Errorf("expected 2 events, got %d", len(inner.received))
+			}
+		},
+	)
+}
+
+func TestRender(t *testing.T) {
+	_, err := New(&fakeSender{}, 1).Render(logevent.LogEvent{})
+	if err == nil {
+		t.Error("expected error from Render() without Renderer but got nil")
+	}
+}
@@ -46,6 +46,35 @@ func (sender *Sess) OpenSvc() error {
 	return nil
 }
 
+// Probe checks that the topic exists and is visible with the configured AWS credentials.
+func (sender *Sess) Probe() error {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sns.New(sess).GetTopicAttributes(&sns.GetTopicAttributesInput{
+		TopicArn: aws.String(sender.snsTopicArn),
+	})
+	return err
+}
+
+// Render returns the message and message attributes SendMessage would publish for a LogEvent.
+func (sender *Sess) Render(logEvent logevent.LogEvent) (logevent.Payload, error) {
+	snsMessage := sender.buildSnsMessage(logEvent)
+	headers := make(map[string]string, len(snsMessage.MessageAttributes))
+	for k, v := range snsMessage.MessageAttributes {
+		headers[k] = aws.StringValue(v.StringValue)
+	}
+	payload := logevent.Payload{
+		Body:    []byte(snsMessage.Message),
+		Headers: headers,
+		Target:  sender.snsTopicArn,
+	}
+	return payload, nil
+}
+
 // SendMessage sends a LogEvent to Amazon Simple Notification Service.
 func (sender *Sess) SendMessage(logEvent logevent.LogEvent) error {
 	if sender.svc == nil {
@@ -3,6 +3,7 @@ package sendsns
 import (
 	"encoding/json"
 	"github.com/djschaap/logevent"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -30,6 +31,31 @@ func TestNew(t *testing.T) {
 	)
 }
 
+func TestRender(t *testing.T) {
+	obj := New("arn:aws:sns:us-east-1:123456789012:t")
+	var _ logevent.Renderer = obj
+	payload, err := obj.Render(logevent.LogEvent{
+		Attributes: logevent.Attributes{
+			CustomerCode: "c1",
+		},
+		Content: logevent.MessageContent{
+			Event: "my event",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() returned unexpected error %v", err)
+	}
+	if payload.Target != "arn:aws:sns:us-east-1:123456789012:t" {
+		t.Errorf("incorrect Target, got %s", payload.Target)
+	}
+	if expect := `{"time":"0001-01-01T00:00:00Z","event":"my event"}`; string(payload.Body) != expect {
+		t.Errorf("expected Body=%s, got %s", expect, payload.Body)
+	}
+	if len(payload.Headers) != 1 || payload.Headers["customer_code"] != "c1" {
+		t.Errorf("incorrect Headers, got %#v", payload.Headers)
+	}
+}
+
 func TestRepeatedOpenAndClose(t *testing.T) {
 	obj := New("t")
 
@@ -138,6 +164,26 @@ func Test_buildSnsMessage_empty_LogEvent(t *testing.T) {
 	)
 }
 
+func Test_buildSnsMessage_metric(t *testing.T) {
+	logEvent := logevent.LogEvent{
+		Content: logevent.MessageContent{
+			Metric: &logevent.Metric{
+				Dimensions: map[string]string{"region": "us-east-1"},
+				Values:     map[string]float64{"cpu.idle": 95.5, "cpu.user": 0.1},
+			},
+		},
+	}
+	m := New("t").buildSnsMessage(logEvent)
+	var content logevent.MessageContent
+	err := json.Unmarshal([]byte(m.Message), &content)
+	if err != nil {
+		t.Fatal("json.Unmarshal error:", err)
+	}
+	if !reflect.DeepEqual(content.Metric, logEvent.Content.Metric) {
+		t.Errorf("expected Metric %#v, got %#v", logEvent.Content.Metric, content.Metric)
+	}
+}
+
 func Test_buildSnsMessage_simple_LogEvent(t *testing.T) {
 	now := time.Now()
 	logEvent := logevent.LogEvent{
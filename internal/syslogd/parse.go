@@ -0,0 +1,245 @@
+package syslogd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// Message is a single parsed syslog message.
+// Empty string fields were either absent or the RFC 5424 NILVALUE ("-").
+type Message struct {
+	AppName        string
+	Facility       int
+	Format         string // "rfc5424" or "rfc3164"
+	Hostname       string
+	Message        string
+	MsgID          string
+	ProcID         string
+	Severity       int
+	StructuredData map[string]map[string]string
+	Timestamp      time.Time
+}
+
+// FacilityName returns the conventional keyword for the message facility.
+func (m Message) FacilityName() string {
+	if m.Facility < 0 || m.Facility >= len(facilityNames) {
+		return strconv.Itoa(m.Facility)
+	}
+	return facilityNames[m.Facility]
+}
+
+// SeverityName returns the conventional keyword for the message severity.
+func (m Message) SeverityName() string {
+	if m.Severity < 0 || m.Severity >= len(severityNames) {
+		return strconv.Itoa(m.Severity)
+	}
+	return severityNames[m.Severity]
+}
+
+// Parse parses an RFC 5424 or RFC 3164 (BSD) syslog message.
+// now is used to infer the year of RFC 3164 timestamps, which do not include one.
+func Parse(b []byte, now time.Time) (Message, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	m := Message{}
+	pri, rest, err := parsePri(b)
+	if err != nil {
+		return m, err
+	}
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+	if bytes.HasPrefix(rest, []byte("1 ")) {
+		m.Format = "rfc5424"
+		err = parse5424(&m, string(rest[2:]))
+	} else {
+		m.Format = "rfc3164"
+		parse3164(&m, string(rest), now)
+	}
+	return m, err
+}
+
+func parsePri(b []byte) (int, []byte, error) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, nil, errors.New("missing PRI")
+	}
+	end := bytes.IndexByte(b[:min(len(b), 5)], '>')
+	if end < 2 {
+		return 0, nil, errors.New("malformed PRI")
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri > 191 {
+		return 0, nil, fmt.Errorf("invalid PRI %q", b[1:end])
+	}
+	return pri, b[end+1:], nil
+}
+
+func parse5424(m *Message, s string) error {
+	var fields [5]string
+	for i := range fields {
+		var token string
+		token, s = nextToken(s)
+		if token == "" {
+			return errors.New("truncated RFC 5424 header")
+		}
+		if token != "-" {
+			fields[i] = token
+		}
+	}
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp: %v", err)
+		}
+		m.Timestamp = t
+	}
+	m.Hostname = fields[1]
+	m.AppName = fields[2]
+	m.ProcID = fields[3]
+	m.MsgID = fields[4]
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		sd, rest, err := parseStructuredData(s)
+		if err != nil {
+			return err
+		}
+		m.StructuredData = sd
+		s = rest
+	} else if s != "" {
+		return errors.New("missing RFC 5424 structured data")
+	}
+	s = strings.TrimPrefix(s, " ")
+	s = strings.TrimPrefix(s, "\ufeff") // BOM
+	m.Message = s
+	return nil
+}
+
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end < 1 {
+			return nil, "", errors.New("malformed structured data element")
+		}
+		id := s[:end]
+		params := make(map[string]string)
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq < 1 {
+				return nil, "", errors.New("malformed structured data parameter")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+				} else if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				} else {
+					value.WriteByte(c)
+				}
+			}
+			if !closed {
+				return nil, "", errors.New("unterminated structured data parameter value")
+			}
+			params[name] = value.String()
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errors.New("unterminated structured data element")
+		}
+		s = s[1:]
+		sd[id] = params
+	}
+	return sd, s, nil
+}
+
+// parse3164 is deliberately lenient; real-world BSD syslog senders vary widely.
+func parse3164(m *Message, s string, now time.Time) {
+	if len(s) >= len(time.Stamp) {
+		t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], now.Location())
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			// a timestamp "in the future" is most likely from December of last year
+			if t.Sub(now) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Timestamp = t
+			s = strings.TrimPrefix(s[len(time.Stamp):], " ")
+		}
+	}
+	if m.Timestamp.IsZero() {
+		token, rest := nextToken(s)
+		t, err := time.Parse(time.RFC3339Nano, token)
+		if err == nil {
+			m.Timestamp = t
+			s = rest
+		}
+	}
+
+	// HOSTNAME follows TIMESTAMP, but is often omitted; a token which looks like a TAG is not a hostname
+	if !m.Timestamp.IsZero() {
+		token, rest := nextToken(s)
+		if token != "" && !strings.HasSuffix(token, ":") && !strings.Contains(token, "[") && rest != "" {
+			m.Hostname = token
+			s = rest
+		}
+	}
+
+	// TAG is only recognized when terminated by ":", as in "app:" or "app[pid]:"
+	tagEnd := strings.IndexAny(s, "[: ")
+	if tagEnd > 0 && tagEnd <= 48 && s[tagEnd] != ' ' {
+		appName := s[:tagEnd]
+		var procID string
+		rest := s[tagEnd:]
+		if strings.HasPrefix(rest, "[") {
+			if end := strings.IndexByte(rest, ']'); end > 0 {
+				procID = rest[1:end]
+				rest = rest[end+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			m.AppName = appName
+			m.ProcID = procID
+			s = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	m.Message = s
+}
+
+func nextToken(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
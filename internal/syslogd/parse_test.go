@@ -0,0 +1,141 @@
+package syslogd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_rfc5424(t *testing.T) {
+	now := time.Now()
+	t.Run("full message",
+		func(t *testing.T) {
+			raw := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 99 ID47 [exampleSDID@32473 iut="3" eventSource="Application \"x\""][examplePriority@32473 class="high"] ` + "\ufeff" + `An application event log entry...`
+			m, err := Parse([]byte(raw), now)
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			if m.Format != "rfc5424" {
+				t.Errorf("expected Format rfc5424, got %#v", m.Format)
+			}
+			if m.FacilityName() != "local4" || m.SeverityName() != "notice" {
+				t.Errorf("expected local4/notice, got %s/%s", m.FacilityName(), m.SeverityName())
+			}
+			expectTime := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)
+			if !m.Timestamp.Equal(expectTime) {
+				t.Errorf("expected Timestamp %v, got %v", expectTime, m.Timestamp)
+			}
+			if m.Hostname != "mymachine.example.com" {
+				t.Errorf("incorrect Hostname, got %#v", m.Hostname)
+			}
+			if m.AppName != "evntslog" || m.ProcID != "99" || m.MsgID != "ID47" {
+				t.Errorf("incorrect AppName/ProcID/MsgID, got %#v/%#v/%#v", m.AppName, m.ProcID, m.MsgID)
+			}
+			if m.StructuredData["exampleSDID@32473"]["eventSource"] != `Application "x"` {
+				t.Errorf("incorrect structured data, got %#v", m.StructuredData)
+			}
+			if m.StructuredData["examplePriority@32473"]["class"] != "high" {
+				t.Errorf("incorrect structured data, got %#v", m.StructuredData)
+			}
+			if m.Message != "An application event log entry..." {
+				t.Errorf("incorrect Message, got %#v", m.Message)
+			}
+		},
+	)
+
+	t.Run("nil values",
+		func(t *testing.T) {
+			m, err := Parse([]byte("<34>1 - - - - - -\n"), now)
+			if err != nil {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			if !m.Timestamp.IsZero() || m.Hostname != "" || m.AppName != "" || m.Message != "" {
+				t.Errorf("expected empty message, got %#v", m)
+			}
+		},
+	)
+
+	errorTests := []struct {
+		name string
+		raw  string
+	}{
+		{"no PRI", "1 - - - - - -"},
+		{"PRI too large", "<192>1 - - - - - -"},
+		{"truncated header", "<34>1 2003-10-11T22:14:15Z host"},
+		{"bad timestamp", "<34>1 yesterday host app - - -"},
+		{"unterminated SD", `<34>1 - host app - - [id a="b"`},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name,
+			func(t *testing.T) {
+				_, err := Parse([]byte(tt.raw), now)
+				if err == nil {
+					t.Error("expected error but got nil")
+				}
+			},
+		)
+	}
+}
+
+func TestParse_rfc3164(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		raw          string
+		expectHost   string
+		expectApp    string
+		expectProcID string
+		expectMsg    string
+		expectTime   time.Time
+	}{
+		{
+			"classic",
+			"<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed",
+			"mymachine", "su", "123", "'su root' failed",
+			time.Date(2019, 10, 11, 22, 14, 15, 0, time.UTC),
+		},
+		{
+			"no hostname",
+			"<13>Jun  5 01:02:03 kernel: link up",
+			"", "kernel", "", "link up",
+			time.Date(2020, 6, 5, 1, 2, 3, 0, time.UTC),
+		},
+		{
+			"no tag",
+			"<13>Jun  5 01:02:03 router interface down",
+			"router", "", "", "interface down",
+			time.Date(2020, 6, 5, 1, 2, 3, 0, time.UTC),
+		},
+		{
+			"no timestamp",
+			"<13>just a message",
+			"", "", "", "just a message",
+			time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name,
+			func(t *testing.T) {
+				m, err := Parse([]byte(tt.raw), now)
+				if err != nil {
+					t.Fatalf("Parse() returned unexpected error %v", err)
+				}
+				if m.Format != "rfc3164" {
+					t.Errorf("expected Format rfc3164, got %#v", m.Format)
+				}
+				if m.Hostname != tt.expectHost {
+					t.Errorf("incorrect Hostname, expected %#v got %#v", tt.expectHost, m.Hostname)
+				}
+				if m.AppName != tt.expectApp || m.ProcID != tt.expectProcID {
+					t.Errorf("incorrect AppName/ProcID, expected %#v/%#v got %#v/%#v",
+						tt.expectApp, tt.expectProcID, m.AppName, m.ProcID)
+				}
+				if m.Message != tt.expectMsg {
+					t.Errorf("incorrect Message, expected %#v got %#v", tt.expectMsg, m.Message)
+				}
+				if !m.Timestamp.Equal(tt.expectTime) {
+					t.Errorf("incorrect Timestamp, expected %v got %v", tt.expectTime, m.Timestamp)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,266 @@
+package syslogd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/kr/pretty"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxMessageSize bounds a single message; octet counts larger than this are rejected.
+const maxMessageSize = 64 * 1024
+
+// Server stores syslogd state; it receives syslog messages and forwards them to a MessageSender.
+type Server struct {
+	closers    map[io.Closer]bool
+	closersMtx sync.Mutex
+	closing    bool
+	sender     logevent.MessageSender
+	senderMtx  sync.Mutex
+	sourcetype string
+	trace      bool
+}
+
+// Close stops all listeners and closes all open TCP connections.
+func (s *Server) Close() error {
+	s.closersMtx.Lock()
+	defer s.closersMtx.Unlock()
+	s.closing = true
+	for c := range s.closers {
+		c.Close()
+	}
+	s.closers = make(map[io.Closer]bool)
+	return nil
+}
+
+// ServeTCP accepts connections from l until Close is called.
+// Each connection may use octet-counted or newline-delimited framing (RFC 6587).
+// l may be a TLS listener.
+func (s *Server) ServeTCP(l net.Listener) error {
+	if !s.track(l) {
+		return errors.New("ServeTCP() called after Close()")
+	}
+	defer s.untrack(l)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// ServeUDP receives one message per datagram from conn until Close is called.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	if !s.track(conn) {
+		return errors.New("ServeUDP() called after Close()")
+	}
+	defer s.untrack(conn)
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			return err
+		}
+		s.handle(buf[:n], addr)
+	}
+}
+
+// SetSourcetype sets the sourcetype of forwarded events (default "syslog").
+func (s *Server) SetSourcetype(v string) {
+	s.sourcetype = v
+}
+
+// SetTrace enables tracing, which dumps all received messages to stderr.
+func (s *Server) SetTrace(v bool) {
+	s.trace = v
+}
+
+func (s *Server) handle(b []byte, addr net.Addr) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return
+	}
+	remoteHost := addr.String()
+	if host, _, err := net.SplitHostPort(remoteHost); err == nil {
+		remoteHost = host
+	}
+	m, err := Parse(b, time.Now())
+	if err != nil {
+		log.Printf("syslogd: unable to parse message from %s (forwarding as-is): %v\n", remoteHost, err)
+		m = Message{Message: string(bytes.TrimRight(b, "\r\n\x00"))}
+	}
+	logEvent := s.toLogEvent(m, remoteHost)
+	s.tracePretty("TRACE_SYSLOGD message =", m, " logEvent =", logEvent)
+
+	s.senderMtx.Lock()
+	defer s.senderMtx.Unlock()
+	err = s.sender.SendMessage(logEvent)
+	if err != nil {
+		log.Printf("syslogd: unable to forward message from %s: %v\n", remoteHost, err)
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.closersMtx.Lock()
+	defer s.closersMtx.Unlock()
+	return s.closing
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(r)
+		if len(frame) > 0 {
+			s.handle(frame, conn.RemoteAddr())
+		}
+		if err != nil {
+			if err != io.EOF && !s.isClosing() {
+				log.Printf("syslogd: closing connection from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) toLogEvent(m Message, remoteHost string) logevent.LogEvent {
+	host := m.Hostname
+	if host == "" {
+		host = remoteHost
+	}
+	fields := map[string]interface{}{
+		"facility": m.FacilityName(),
+		"severity": m.SeverityName(),
+	}
+	if m.AppName != "" {
+		fields["appname"] = m.AppName
+	}
+	if m.ProcID != "" {
+		fields["procid"] = m.ProcID
+	}
+	if m.MsgID != "" {
+		fields["msgid"] = m.MsgID
+	}
+	for id, params := range m.StructuredData {
+		for k, v := range params {
+			fields["sd."+id+"."+k] = v
+		}
+	}
+	logEvent := logevent.LogEvent{
+		Attributes: logevent.Attributes{
+			Host:       host,
+			Source:     m.AppName,
+			Sourcetype: s.sourcetype,
+		},
+		Content: logevent.MessageContent{
+			Event:      m.Message,
+			Fields:     fields,
+			Host:       host,
+			Source:     m.AppName,
+			Sourcetype: s.sourcetype,
+			Time:       m.Timestamp,
+		},
+	}
+	if m.Format == "" {
+		// unparseable; PRI-derived fields are meaningless
+		delete(fields, "facility")
+		delete(fields, "severity")
+	}
+	return logEvent
+}
+
+func (s *Server) tracePretty(
+	args ...interface{},
+) {
+	if s.trace {
+		pretty.Log(args...)
+	}
+}
+
+func (s *Server) track(c io.Closer) bool {
+	s.closersMtx.Lock()
+	defer s.closersMtx.Unlock()
+	if s.closing {
+		return false
+	}
+	s.closers[c] = true
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.closersMtx.Lock()
+	defer s.closersMtx.Unlock()
+	delete(s.closers, c)
+}
+
+// readFrame reads one message from a stream.
+// A frame starting with a digit is octet-counted ("LEN SP MSG");
+// anything else is terminated by LF. Either way, a message larger than maxMessageSize is an error.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '0' && first[0] <= '9' {
+		lenBytes, err := r.ReadSlice(' ')
+		lenStr := string(lenBytes)
+		if err != nil {
+			return nil, fmt.Errorf("truncated octet count: %v", err)
+		}
+		n, err := strconv.Atoi(lenStr[:len(lenStr)-1])
+		if err != nil || n < 0 || n > maxMessageSize {
+			return nil, fmt.Errorf("invalid octet count %q", lenStr)
+		}
+		frame := make([]byte, n)
+		_, err = io.ReadFull(r, frame)
+		if err != nil {
+			return nil, fmt.Errorf("truncated frame: %v", err)
+		}
+		return frame, nil
+	}
+	var frame []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(frame)+len(line) > maxMessageSize+1 { // LF not counted
+			return nil, fmt.Errorf("frame exceeds %d bytes", maxMessageSize)
+		}
+		frame = append(frame, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(frame) > 0 {
+			// final frame without trailing LF
+			return frame, io.EOF
+		}
+		return frame, err
+	}
+}
+
+// New creates a new syslogd server which forwards to an open MessageSender.
+func New(sender logevent.MessageSender) *Server {
+	s := Server{
+		closers:    make(map[io.Closer]bool),
+		sender:     sender,
+		sourcetype: "syslog",
+	}
+	return &s
+}
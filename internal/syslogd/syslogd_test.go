@@ -0,0 +1,266 @@
+package syslogd
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/djschaap/logevent"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mtx      sync.Mutex
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+// waitFor polls until the sender has received n events or a deadline passes.
+func (s *fakeSender) waitFor(n int) []logevent.LogEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mtx.Lock()
+		if len(s.received) >= n {
+			received := append([]logevent.LogEvent{}, s.received...)
+			s.mtx.Unlock()
+			return received
+		}
+		s.mtx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]logevent.LogEvent{}, s.received...)
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func Test_readFrame(t *testing.T) {
+	input := "5 <13>a" + "<13>line one\n" + "13 <13>two\nthree" + "<13>last"
+	r := bufio.NewReader(strings.NewReader(input))
+	expect := []string{"<13>a", "<13>line one\n", "<13>two\nthree", "<13>last"}
+	for i, e := range expect {
+		frame, err := readFrame(r)
+		if string(frame) != e {
+			t.Errorf("frame %d: expected %#v got %#v", i, e, string(frame))
+		}
+		if i < len(expect)-1 && err != nil {
+			t.Errorf("frame %d: unexpected error %v", i, err)
+		}
+	}
+	_, err := readFrame(r)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	t.Run("bad octet count",
+		func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader("99999999 x"))
+			_, err := readFrame(r)
+			if err == nil {
+				t.Error("expected error but got nil")
+			}
+		},
+	)
+
+	t.Run("long octet count",
+		func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(strings.Repeat("9", 8192) + " x"))
+			_, err := readFrame(r)
+			if err == nil {
+				t.Error("expected error but got nil")
+			}
+		},
+	)
+
+	t.Run("LF frame at maxMessageSize",
+		func(t *testing.T) {
+			line := strings.Repeat("x", maxMessageSize)
+			r := bufio.NewReader(strings.NewReader(line + "\n"))
+			frame, err := readFrame(r)
+			if err != nil || string(frame) != line+"\n" {
+				t.Errorf("expected %d-byte frame, got %d bytes, error %v", maxMessageSize, len(frame), err)
+			}
+		},
+	)
+
+	t.Run("LF frame over maxMessageSize",
+		func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(strings.Repeat("x", maxMessageSize+1) + "\n"))
+			frame, err := readFrame(r)
+			if err == nil || err == io.EOF || frame != nil {
+				t.Errorf("expected error and no frame, got %d bytes, error %v", len(frame), err)
+			}
+		},
+	)
+}
+
+func Test_toLogEvent(t *testing.T) {
+	s := New(&fakeSender{})
+	ts := time.Date(2003, 10, 11, 22, 14, 15, 0, time.UTC)
+	m := Message{
+		AppName:        "app",
+		Facility:       16,
+		Format:         "rfc5424",
+		Hostname:       "h1",
+		Message:        "hello",
+		MsgID:          "ID1",
+		ProcID:         "42",
+		Severity:       3,
+		StructuredData: map[string]map[string]string{"meta": {"k": "v"}},
+		Timestamp:      ts,
+	}
+	logEvent := s.toLogEvent(m, "10.0.0.1")
+	if logEvent.Attributes.Host != "h1" || logEvent.Content.Host != "h1" {
+		t.Errorf("incorrect Host, got %#v", logEvent)
+	}
+	if logEvent.Content.Source != "app" || logEvent.Content.Sourcetype != "syslog" {
+		t.Errorf("incorrect Source/Sourcetype, got %#v/%#v", logEvent.Content.Source, logEvent.Content.Sourcetype)
+	}
+	if logEvent.Content.Event != "hello" || !logEvent.Content.Time.Equal(ts) {
+		t.Errorf("incorrect Event/Time, got %#v", logEvent.Content)
+	}
+	expectFields := map[string]string{
+		"facility":  "local0",
+		"severity":  "err",
+		"appname":   "app",
+		"procid":    "42",
+		"msgid":     "ID1",
+		"sd.meta.k": "v",
+	}
+	for k, v := range expectFields {
+		if logEvent.Content.Fields[k] != v {
+			t.Errorf("incorrect field %s, expected %#v got %#v", k, v, logEvent.Content.Fields[k])
+		}
+	}
+
+	t.Run("host falls back to peer address",
+		func(t *testing.T) {
+			logEvent := s.toLogEvent(Message{Format: "rfc3164"}, "10.0.0.1")
+			if logEvent.Content.Host != "10.0.0.1" {
+				t.Errorf("expected Host 10.0.0.1, got %#v", logEvent.Content.Host)
+			}
+		},
+	)
+}
+
+func TestServeUDP(t *testing.T) {
+	sender := &fakeSender{}
+	s := New(sender)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeUDP(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("<34>1 2003-10-11T22:14:15Z h1 app - - - via udp"))
+
+	received := sender.waitFor(1)
+	if len(received) != 1 || received[0].Content.Event != "via udp" {
+		t.Errorf("expected one event \"via udp\", got %#v", received)
+	}
+	s.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeUDP() returned unexpected error %v", err)
+	}
+}
+
+func TestServeTCP(t *testing.T) {
+	sender := &fakeSender{}
+	s := New(sender)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeTCP(l) }()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("<13>Oct 11 22:14:15 h1 app: one\n17 <13>two\nstill two"))
+	client.Close()
+
+	received := sender.waitFor(2)
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events, got %#v", received)
+	}
+	if received[0].Content.Event != "one" || received[0].Content.Host != "h1" {
+		t.Errorf("incorrect first event, got %#v", received[0].Content)
+	}
+	if received[1].Content.Event != "two\nstill two" {
+		t.Errorf("incorrect second event, got %#v", received[1].Content.Event)
+	}
+	s.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeTCP() returned unexpected error %v", err)
+	}
+}
+
+func TestServeTCP_tls(t *testing.T) {
+	sender := &fakeSender{}
+	s := New(sender)
+	cert := selfSignedCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeTCP(l)
+	defer s.Close()
+
+	client, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("<13>1 - h1 app - - - via tls\n"))
+	client.Close()
+
+	received := sender.waitFor(1)
+	if len(received) != 1 || received[0].Content.Event != "via tls" {
+		t.Errorf("expected one event \"via tls\", got %#v", received)
+	}
+}
@@ -0,0 +1,475 @@
+package tail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/multiline"
+	"github.com/kr/pretty"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxLineSize bounds a single line; longer lines are split.
+const maxLineSize = 64 * 1024
+
+// signatureSize is the number of leading bytes used to recognize a file across restarts.
+const signatureSize = 256
+
+// checkpoint records how far a file has been sent.
+// Signature is a hash of the first SignatureLen bytes of the file, so a replaced
+// file with the same name is not mistaken for the one previously read.
+type checkpoint struct {
+	Offset       int64  `json:"offset"`
+	Signature    string `json:"signature"`
+	SignatureLen int    `json:"signature_len"`
+}
+
+type tailedFile struct {
+	agg        *multiline.Aggregator // nil unless multi-line records are enabled
+	f          *os.File
+	offset     int64 // everything before offset has been sent
+	path       string
+	readOffset int64 // everything before readOffset has been read; may exceed offset while a record is pending
+	sig        string
+	sigLen     int // bytes hashed for sig; below signatureSize while the file is shorter
+}
+
+// Tailer stores tail state; it follows files matching one or more globs and sends each line as a LogEvent.
+type Tailer struct {
+	checkpointFile string
+	checkpoints    map[string]checkpoint
+	files          map[string]*tailedFile
+	globs          []string
+	host           string
+	index          string
+	multiline      *multiline.Config
+	pollInterval   time.Duration
+	sender         logevent.MessageSender
+	sourcetype     string
+	startAtEnd     bool
+	trace          bool
+}
+
+// Close closes all open files and writes the checkpoint file.
+func (t *Tailer) Close() error {
+	for path, tf := range t.files {
+		tf.f.Close()
+		delete(t.files, path)
+	}
+	return t.saveCheckpoints()
+}
+
+// Poll performs one pass: it discovers new files, sends any new lines, handles
+// rotation and truncation, and writes the checkpoint file.
+func (t *Tailer) Poll() error {
+	err := t.discover()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	paths := make([]string, 0, len(t.files))
+	for path := range t.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		err := t.pollFile(t.files[path])
+		if err != nil && sendErr == nil {
+			sendErr = err
+		}
+	}
+	err = t.saveCheckpoints()
+	if sendErr != nil {
+		return sendErr
+	}
+	return err
+}
+
+// Run polls until stop is closed, then closes the Tailer.
+// Send failures are logged and retried on the next poll.
+func (t *Tailer) Run(stop <-chan struct{}) error {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := t.Poll()
+		if err != nil {
+			log.Println("tail:", err)
+		}
+		select {
+		case <-stop:
+			return t.Close()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetCheckpointFile sets where read offsets are persisted; existing checkpoints are loaded.
+func (t *Tailer) SetCheckpointFile(path string) error {
+	t.checkpointFile = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &t.checkpoints)
+}
+
+// SetHost sets the host of sent events (default: this host's name).
+func (t *Tailer) SetHost(v string) {
+	t.host = v
+}
+
+// SetIndex sets the index of sent events.
+func (t *Tailer) SetIndex(v string) {
+	t.index = v
+}
+
+// SetMultiline enables assembly of multi-line records (such as stack traces) before sending.
+// A record is only checkpointed once it has been sent, so a pending record is re-read after a restart.
+func (t *Tailer) SetMultiline(cfg multiline.Config) {
+	t.multiline = &cfg
+}
+
+// SetPollInterval sets how often files are checked for new data.
+func (t *Tailer) SetPollInterval(v time.Duration) {
+	t.pollInterval = v
+}
+
+// SetSourcetype sets the sourcetype of sent events.
+func (t *Tailer) SetSourcetype(v string) {
+	t.sourcetype = v
+}
+
+// SetStartAtEnd skips existing content of files which have no checkpoint when first seen at startup.
+// Files which appear later are always read from the beginning.
+func (t *Tailer) SetStartAtEnd(v bool) {
+	t.startAtEnd = v
+}
+
+// SetTrace enables tracing, which dumps all messages to stderr.
+func (t *Tailer) SetTrace(v bool) {
+	t.trace = v
+}
+
+// discover opens files which newly match the globs.
+func (t *Tailer) discover() error {
+	firstPass := t.files == nil
+	if firstPass {
+		t.files = make(map[string]*tailedFile)
+	}
+	for _, pattern := range t.globs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+		for _, path := range matches {
+			if _, ok := t.files[path]; ok {
+				continue
+			}
+			st, err := os.Stat(path)
+			if err != nil || st.IsDir() {
+				continue
+			}
+			tf, err := t.open(path, firstPass)
+			if err != nil {
+				log.Println("tail:", err)
+				continue
+			}
+			t.files[path] = tf
+		}
+	}
+	return nil
+}
+
+func (t *Tailer) newLogEvent(path string, line string) logevent.LogEvent {
+	return logevent.LogEvent{
+		Attributes: logevent.Attributes{
+			Host:       t.host,
+			Source:     path,
+			Sourcetype: t.sourcetype,
+		},
+		Content: logevent.MessageContent{
+			Event:      line,
+			Host:       t.host,
+			Index:      t.index,
+			Source:     path,
+			Sourcetype: t.sourcetype,
+			Time:       time.Now(),
+		},
+	}
+}
+
+// open opens a file and positions it at its checkpoint, if the checkpoint still matches.
+func (t *Tailer) open(path string, atStartup bool) (*tailedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tf := &tailedFile{f: f, path: path}
+	if cp, ok := t.checkpoints[path]; ok {
+		sig, sigLen := signature(f, cp.SignatureLen)
+		st, err := f.Stat()
+		if err == nil && sigLen == cp.SignatureLen && sig == cp.Signature && cp.Offset <= st.Size() {
+			tf.offset = cp.Offset
+		} else {
+			log.Printf("tail: %s does not match its checkpoint (rotated?); reading from start\n", path)
+		}
+	} else if atStartup && t.startAtEnd {
+		st, err := f.Stat()
+		if err == nil {
+			tf.offset = st.Size()
+		}
+	}
+	tf.readOffset = tf.offset
+	tf.sig, tf.sigLen = signature(f, signatureSize)
+	if t.multiline != nil {
+		tf.agg = multiline.New(*t.multiline)
+	}
+	t.tracePrintln("tail: following", path, "from offset", tf.offset)
+	return tf, nil
+}
+
+// pollFile checks for truncation, sends complete lines written since the last poll, then checks for rotation.
+func (t *Tailer) pollFile(tf *tailedFile) error {
+	fst, err := tf.f.Stat()
+	if err != nil {
+		return err
+	}
+	sig, sigLen := signature(tf.f, tf.sigLen)
+	truncated := fst.Size() < tf.readOffset || sigLen < tf.sigLen || sig != tf.sig
+	if truncated || tf.sigLen < signatureSize {
+		tf.sig, tf.sigLen = signature(tf.f, signatureSize)
+	}
+	if truncated {
+		// truncated in place (e.g. copytruncate), possibly since grown past the offset
+		t.tracePrintln("tail:", tf.path, "truncated")
+		tf.offset = 0
+		tf.readOffset = 0
+		if tf.agg != nil {
+			tf.agg.Reset()
+		}
+		t.updateCheckpoint(tf)
+	}
+
+	err = t.readLines(tf, false)
+	if err != nil {
+		return err
+	}
+	if tf.agg != nil && tf.agg.Expired(time.Now()) {
+		err := t.flushRecord(tf)
+		if err != nil {
+			return err
+		}
+	}
+
+	st, statErr := os.Stat(tf.path)
+	fst, err = tf.f.Stat()
+	if err != nil {
+		return err
+	}
+	if statErr != nil || !os.SameFile(st, fst) {
+		// rotated by rename (or removed); the old file has been drained above,
+		// except for a final line without a trailing newline
+		err := t.readLines(tf, true)
+		if err != nil {
+			return err
+		}
+		tf.f.Close()
+		delete(t.files, tf.path)
+		delete(t.checkpoints, tf.path)
+		t.tracePrintln("tail:", tf.path, "rotated")
+		if statErr != nil {
+			return nil
+		}
+		newTf, err := t.open(tf.path, false)
+		if err != nil {
+			return err
+		}
+		t.files[tf.path] = newTf
+		return t.readLines(newTf, false)
+	}
+	return nil
+}
+
+// readLines reads each complete line after tf.readOffset and passes it to addLine.
+// When final is true, a trailing partial line and any pending record are also sent.
+func (t *Tailer) readLines(tf *tailedFile, final bool) error {
+	buf := make([]byte, maxLineSize)
+	for {
+		n, err := tf.f.ReadAt(buf, tf.readOffset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		data := buf[:n]
+		used := 0
+		for {
+			i := bytes.IndexByte(data[used:], '\n')
+			if i < 0 {
+				break
+			}
+			err := t.addLine(tf, data[used:used+i], i+1)
+			if err != nil {
+				return err
+			}
+			used += i + 1
+		}
+		if used == 0 {
+			if n == maxLineSize || final {
+				// line too long to buffer, or last line of a rotated file
+				err := t.addLine(tf, data, n)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			break
+		}
+		if err == io.EOF && !final {
+			break
+		}
+	}
+	if final {
+		return t.flushRecord(tf)
+	}
+	return nil
+}
+
+// addLine sends a line, or adds it to the pending multi-line record and sends any completed records.
+func (t *Tailer) addLine(tf *tailedFile, line []byte, consumed int) error {
+	lineStart := tf.readOffset
+	tf.readOffset += int64(consumed)
+	text := string(bytes.TrimRight(line, "\r"))
+	if tf.agg == nil {
+		return t.sendRecord(tf, text, tf.readOffset)
+	}
+	records := tf.agg.Add(text)
+	for i, record := range records {
+		// a completed record ends before this line, unless this line completed it (MaxLines)
+		committed := lineStart
+		if i == len(records)-1 && tf.agg.Len() == 0 {
+			committed = tf.readOffset
+		}
+		err := t.sendRecord(tf, record, committed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushRecord sends the pending multi-line record, if any.
+func (t *Tailer) flushRecord(tf *tailedFile) error {
+	if tf.agg == nil {
+		return nil
+	}
+	record, ok := tf.agg.Flush()
+	if !ok {
+		return nil
+	}
+	return t.sendRecord(tf, record, tf.readOffset)
+}
+
+func (t *Tailer) saveCheckpoints() error {
+	if t.checkpointFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(t.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.checkpointFile + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.checkpointFile)
+}
+
+// sendRecord sends a record and then checkpoints committed as the new offset.
+// On failure, reading restarts from the last checkpointed offset on the next poll.
+func (t *Tailer) sendRecord(tf *tailedFile, record string, committed int64) error {
+	if record != "" {
+		logEvent := t.newLogEvent(tf.path, record)
+		t.tracePretty("TRACE_TAIL logEvent =", logEvent)
+		err := t.sender.SendMessage(logEvent)
+		if err != nil {
+			tf.readOffset = tf.offset
+			if tf.agg != nil {
+				tf.agg.Reset()
+			}
+			return fmt.Errorf("unable to send record from %s at offset %d: %v", tf.path, tf.offset, err)
+		}
+	}
+	tf.offset = committed
+	t.updateCheckpoint(tf)
+	return nil
+}
+
+func (t *Tailer) tracePretty(
+	args ...interface{},
+) {
+	if t.trace {
+		pretty.Log(args...)
+	}
+}
+
+func (t *Tailer) tracePrintln(
+	args ...interface{},
+) {
+	if t.trace {
+		log.Println(args...)
+	}
+}
+
+// updateCheckpoint records tf's offset with the signature computed when it was opened or last polled.
+func (t *Tailer) updateCheckpoint(tf *tailedFile) {
+	t.checkpoints[tf.path] = checkpoint{
+		Offset:       tf.offset,
+		Signature:    tf.sig,
+		SignatureLen: tf.sigLen,
+	}
+}
+
+// signature hashes up to the first n bytes of f; it also returns how many bytes were hashed.
+func signature(f *os.File, n int) (string, int) {
+	buf := make([]byte, n)
+	n, _ = f.ReadAt(buf, 0)
+	sum := sha256.Sum256(buf[:n])
+	return hex.EncodeToString(sum[:]), n
+}
+
+// New creates a new Tailer which follows files matching globs and sends to an open MessageSender.
+func New(sender logevent.MessageSender, globs []string) (*Tailer, error) {
+	if len(globs) == 0 {
+		return nil, errors.New("at least one file glob is required")
+	}
+	for _, pattern := range globs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+	host, _ := os.Hostname()
+	t := Tailer{
+		checkpoints:  make(map[string]checkpoint),
+		globs:        globs,
+		host:         host,
+		pollInterval: time.Second,
+		sender:       sender,
+	}
+	return &t, nil
+}
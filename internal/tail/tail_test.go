@@ -0,0 +1,292 @@
+package tail
+
+import (
+	"errors"
+	"github.com/djschaap/logevent"
+	"github.com/djschaap/logevent/multiline"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	err      error
+	received []logevent.LogEvent
+}
+
+func (s *fakeSender) CloseSvc() error { return nil }
+func (s *fakeSender) OpenSvc() error  { return nil }
+func (s *fakeSender) SetTrace(bool)   {}
+func (s *fakeSender) SendMessage(logEvent logevent.LogEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.received = append(s.received, logEvent)
+	return nil
+}
+
+func (s *fakeSender) events() []string {
+	var events []string
+	for _, logEvent := range s.received {
+		events = append(events, logEvent.Content.Event.(string))
+	}
+	return events
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectEvents(t *testing.T, sender *fakeSender, expect ...string) {
+	t.Helper()
+	got := sender.events()
+	if len(got) != len(expect) {
+		t.Fatalf("expected events %#v, got %#v", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Errorf("expected events %#v, got %#v", expect, got)
+			return
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logevent-tail-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&fakeSender{}, nil)
+	if err == nil {
+		t.Error("expected error with no globs but got nil")
+	}
+	_, err = New(&fakeSender{}, []string{"["})
+	if err == nil {
+		t.Error("expected error with invalid glob but got nil")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "one\r\ntwo\npart")
+
+	sender := &fakeSender{}
+	tailer, err := New(sender, []string{filepath.Join(dir, "*.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.Close()
+	tailer.SetSourcetype("st1")
+	tailer.SetIndex("idx1")
+	tailer.SetHost("h1")
+
+	err = tailer.Poll()
+	if err != nil {
+		t.Fatalf("Poll() returned unexpected error %v", err)
+	}
+	expectEvents(t, sender, "one", "two")
+	c := sender.received[0].Content
+	if c.Source != path || c.Sourcetype != "st1" || c.Index != "idx1" || c.Host != "h1" {
+		t.Errorf("incorrect metadata, got %#v", c)
+	}
+
+	t.Run("partial line completed",
+		func(t *testing.T) {
+			appendFile(t, path, "ial\nthree\n")
+			tailer.Poll()
+			expectEvents(t, sender, "one", "two", "partial", "three")
+		},
+	)
+
+	t.Run("truncated",
+		func(t *testing.T) {
+			err := ioutil.WriteFile(path, []byte("four\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tailer.Poll()
+			expectEvents(t, sender, "one", "two", "partial", "three", "four")
+		},
+	)
+
+	t.Run("rotated by rename",
+		func(t *testing.T) {
+			appendFile(t, path, "five")
+			err := os.Rename(path, path+".1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			appendFile(t, path, "six\n")
+			tailer.Poll()
+			expectEvents(t, sender, "one", "two", "partial", "three", "four", "five", "six")
+		},
+	)
+
+	t.Run("new file",
+		func(t *testing.T) {
+			appendFile(t, filepath.Join(dir, "other.log"), "seven\n")
+			tailer.Poll()
+			expectEvents(t, sender, "one", "two", "partial", "three", "four", "five", "six", "seven")
+		},
+	)
+}
+
+func TestPoll_truncatedAndGrown(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "one\n")
+
+	sender := &fakeSender{}
+	tailer, err := New(sender, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.Close()
+	tailer.Poll()
+	expectEvents(t, sender, "one")
+
+	// copytruncate, then more written than had been read before the next poll
+	err = ioutil.WriteFile(path, []byte("two\nthree\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer.Poll()
+	expectEvents(t, sender, "one", "two", "three")
+	if cp := tailer.checkpoints[path]; cp.Offset != 10 || cp.SignatureLen != 10 {
+		t.Errorf("expected checkpoint at offset 10 with 10-byte signature, got %+v", cp)
+	}
+}
+
+func TestPoll_sendFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "one\n")
+
+	sender := &fakeSender{err: errors.New("down")}
+	tailer, _ := New(sender, []string{path})
+	defer tailer.Close()
+	err := tailer.Poll()
+	if err == nil {
+		t.Error("expected error from Poll() but got nil")
+	}
+	sender.err = nil
+	err = tailer.Poll()
+	if err != nil {
+		t.Errorf("Poll() returned unexpected error %v", err)
+	}
+	expectEvents(t, sender, "one")
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	cpFile := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, "one\n")
+
+	sender := &fakeSender{}
+	tailer, _ := New(sender, []string{path})
+	err := tailer.SetCheckpointFile(cpFile)
+	if err != nil {
+		t.Fatalf("SetCheckpointFile() returned unexpected error %v", err)
+	}
+	tailer.Poll()
+	tailer.Close()
+	appendFile(t, path, "two\n")
+
+	t.Run("resume from checkpoint",
+		func(t *testing.T) {
+			sender := &fakeSender{}
+			tailer, _ := New(sender, []string{path})
+			tailer.SetCheckpointFile(cpFile)
+			tailer.Poll()
+			tailer.Close()
+			expectEvents(t, sender, "two")
+		},
+	)
+
+	t.Run("replaced file is read from start",
+		func(t *testing.T) {
+			ioutil.WriteFile(path, []byte("new one\nnew two\n"), 0644)
+			sender := &fakeSender{}
+			tailer, _ := New(sender, []string{path})
+			tailer.SetCheckpointFile(cpFile)
+			tailer.Poll()
+			tailer.Close()
+			expectEvents(t, sender, "new one", "new two")
+		},
+	)
+}
+
+func TestSetStartAtEnd(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "old\n")
+
+	sender := &fakeSender{}
+	tailer, _ := New(sender, []string{filepath.Join(dir, "*.log")})
+	defer tailer.Close()
+	tailer.SetStartAtEnd(true)
+	tailer.Poll()
+	appendFile(t, path, "new\n")
+	appendFile(t, filepath.Join(dir, "later.log"), "later\n")
+	tailer.Poll()
+	expectEvents(t, sender, "new", "later")
+}
+
+func TestSetMultiline(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	cpFile := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, "one\n  a\n  b\ntwo\n  c\n")
+
+	sender := &fakeSender{}
+	tailer, _ := New(sender, []string{path})
+	tailer.SetCheckpointFile(cpFile)
+	tailer.SetMultiline(multiline.Config{
+		Continue: regexp.MustCompile(`^\s`),
+		Timeout:  time.Hour,
+	})
+	tailer.Poll()
+	expectEvents(t, sender, "one\n  a\n  b")
+	tailer.Close()
+
+	t.Run("pending record is re-read after restart",
+		func(t *testing.T) {
+			sender := &fakeSender{}
+			tailer, _ := New(sender, []string{path})
+			defer tailer.Close()
+			tailer.SetCheckpointFile(cpFile)
+			tailer.SetMultiline(multiline.Config{
+				Continue: regexp.MustCompile(`^\s`),
+				Timeout:  50 * time.Millisecond,
+			})
+			tailer.Poll()
+			expectEvents(t, sender)
+			time.Sleep(100 * time.Millisecond)
+			tailer.Poll()
+			expectEvents(t, sender, "two\n  c")
+		},
+	)
+}
@@ -0,0 +1,108 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Settings are the TLS options shared by senders which connect over TLS, such as sendhec and sendamqp.
+// The zero value uses the system CA certificates and Go's defaults.
+type Settings struct {
+	CAFile       string   `json:"ca_file,omitempty"`       // PEM CA certificates to trust, in place of the system's
+	CertFile     string   `json:"cert_file,omitempty"`     // PEM client certificate, for mutual TLS; requires KeyFile
+	CipherSuites []string `json:"cipher_suites,omitempty"` // TLS 1.0-1.2 cipher suites, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	KeyFile      string   `json:"key_file,omitempty"`      // PEM client private key; requires CertFile
+	MinVersion   string   `json:"min_version,omitempty"`   // 1.0, 1.1, 1.2 or 1.3
+	ServerName   string   `json:"server_name,omitempty"`   // expected in the server certificate, in place of the host name
+}
+
+// cipherSuites are the cipher suites which may be named in Settings.CipherSuites.
+// TLS 1.3 cipher suites are not configurable.
+var cipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// IsZero returns true when s sets no options.
+func (s Settings) IsZero() bool {
+	return s.CAFile == "" && s.CertFile == "" && len(s.CipherSuites) == 0 &&
+		s.KeyFile == "" && s.MinVersion == "" && s.ServerName == ""
+}
+
+// Config returns a tls.Config for s, reading its certificate and key files.
+// It returns nil when s sets no options.
+func (s Settings) Config() (*tls.Config, error) {
+	if s.IsZero() {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: s.ServerName}
+	if s.CAFile != "" {
+		pem, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("CA file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", s.CAFile)
+		}
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return nil, errors.New("a client certificate requires both a certificate file and a key file")
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if s.MinVersion != "" {
+		v, ok := versions[s.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("minimum version %q is not valid; use 1.0, 1.1, 1.2 or 1.3", s.MinVersion)
+		}
+		config.MinVersion = v
+	}
+	for _, name := range s.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("cipher suite %q is not valid; use one of %s", name, strings.Join(CipherSuiteNames(), ", "))
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
+
+// CipherSuiteNames returns the cipher suites which may be named in Settings.CipherSuites, sorted.
+func CipherSuiteNames() []string {
+	names := make([]string, 0, len(cipherSuites))
+	for name := range cipherSuites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
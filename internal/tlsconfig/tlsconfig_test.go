@@ -0,0 +1,242 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI is a self-signed CA with a server certificate for hec.test and a client certificate,
+// written as PEM files to dir.
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+	server tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPKI{dir: dir}
+	p.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotAfter:              time.Now().Add(time.Hour),
+		NotBefore:             time.Now().Add(-time.Hour),
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &p.caKey.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ca, _ = x509.ParseCertificate(der)
+	p.caPool = x509.NewCertPool()
+	p.caPool.AddCert(p.ca)
+	p.writePEM(t, "ca.pem", "CERTIFICATE", der)
+
+	p.server = p.issue(t, "server", x509.ExtKeyUsageServerAuth, "hec.test")
+	p.issue(t, "client", x509.ExtKeyUsageClientAuth, "")
+	return p
+}
+
+// issue creates a certificate signed by the CA, writes NAME.pem and NAME-key.pem, and returns it.
+func (p *testPKI) issue(t *testing.T, name string, usage x509.ExtKeyUsage, dnsName string) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+	}
+	if dnsName != "" {
+		template.DNSNames = []string{dnsName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	p.writePEM(t, name+".pem", "CERTIFICATE", der)
+	p.writePEM(t, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	err := ioutil.WriteFile(p.path(name), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// newServer starts an HTTPS server with the server certificate; configure may change its tls.Config.
+func (p *testPKI) newServer(configure func(*tls.Config)) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{p.server}}
+	if configure != nil {
+		configure(ts.TLS)
+	}
+	ts.StartTLS()
+	return ts
+}
+
+func get(config *tls.Config, url string) error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func TestSettings_Config(t *testing.T) {
+	p := newTestPKI(t)
+	defer os.RemoveAll(p.dir)
+
+	t.Run("zero",
+		func(t *testing.T) {
+			config, err := Settings{}.Config()
+			if config != nil || err != nil {
+				t.Errorf("expected nil, nil, got %v, %v", config, err)
+			}
+		},
+	)
+
+	t.Run("CA file and server name",
+		func(t *testing.T) {
+			ts := p.newServer(nil)
+			defer ts.Close()
+			config, err := Settings{CAFile: p.path("ca.pem"), ServerName: "hec.test"}.Config()
+			if err != nil {
+				t.Fatalf("Config() returned unexpected error %v", err)
+			}
+			err = get(config, ts.URL)
+			if err != nil {
+				t.Errorf("expected success, got %v", err)
+			}
+			config.ServerName = ""
+			err = get(config, ts.URL)
+			if err == nil {
+				t.Error("expected error without server name but got nil")
+			}
+		},
+	)
+
+	t.Run("client certificate",
+		func(t *testing.T) {
+			ts := p.newServer(func(c *tls.Config) {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.ClientCAs = p.caPool
+			})
+			defer ts.Close()
+			settings := Settings{CAFile: p.path("ca.pem"), ServerName: "hec.test"}
+			config, _ := settings.Config()
+			err := get(config, ts.URL)
+			if err == nil {
+				t.Error("expected error without client certificate but got nil")
+			}
+			settings.CertFile = p.path("client.pem")
+			settings.KeyFile = p.path("client-key.pem")
+			config, err = settings.Config()
+			if err != nil {
+				t.Fatalf("Config() returned unexpected error %v", err)
+			}
+			err = get(config, ts.URL)
+			if err != nil {
+				t.Errorf("expected success, got %v", err)
+			}
+		},
+	)
+
+	t.Run("minimum version and cipher suites",
+		func(t *testing.T) {
+			ts := p.newServer(func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+				c.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+			})
+			defer ts.Close()
+			settings := Settings{
+				CAFile:       p.path("ca.pem"),
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				MinVersion:   "1.2",
+				ServerName:   "hec.test",
+			}
+			config, err := settings.Config()
+			if err != nil {
+				t.Fatalf("Config() returned unexpected error %v", err)
+			}
+			err = get(config, ts.URL)
+			if err != nil {
+				t.Errorf("expected success, got %v", err)
+			}
+			settings.MinVersion = "1.3"
+			config, _ = settings.Config()
+			err = get(config, ts.URL)
+			if err == nil {
+				t.Error("expected error with minimum version 1.3 but got nil")
+			}
+			settings.MinVersion = ""
+			settings.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+			config, _ = settings.Config()
+			config.MaxVersion = tls.VersionTLS12
+			err = get(config, ts.URL)
+			if err == nil {
+				t.Error("expected error without a common cipher suite but got nil")
+			}
+		},
+	)
+}
+
+func TestSettings_Config_errors(t *testing.T) {
+	p := newTestPKI(t)
+	defer os.RemoveAll(p.dir)
+	tests := []struct {
+		name     string
+		settings Settings
+		message  string
+	}{
+		{"missing CA file", Settings{CAFile: p.path("missing.pem")}, "CA file: open"},
+		{"CA file without certificates", Settings{CAFile: p.path("client-key.pem")}, "contains no PEM certificates"},
+		{"certificate without key", Settings{CertFile: p.path("client.pem")}, "both a certificate file and a key file"},
+		{"mismatched key", Settings{CertFile: p.path("client.pem"), KeyFile: p.path("server-key.pem")}, "client certificate:"},
+		{"bad version", Settings{MinVersion: "1.4"}, `minimum version "1.4" is not valid`},
+		{"bad cipher suite", Settings{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, `cipher suite "TLS_RSA_WITH_RC4_128_SHA" is not valid`},
+	}
+	for _, test := range tests {
+		t.Run(test.name,
+			func(t *testing.T) {
+				_, err := test.settings.Config()
+				if err == nil || !strings.Contains(err.Error(), test.message) {
+					t.Errorf("expected error containing %q, got %v", test.message, err)
+				}
+			},
+		)
+	}
+}
@@ -4,6 +4,12 @@ import (
 	"time"
 )
 
+// BatchSender is implemented by a MessageSender which can deliver several LogEvents in a single request.
+// Callers should prefer SendBatch over repeated SendMessage calls when it is available.
+type BatchSender interface {
+	SendBatch([]LogEvent) error
+}
+
 // MessageSender is the interface that manages a connection to an external LogEvent destination.
 type MessageSender interface {
 	CloseSvc() error
@@ -12,6 +18,18 @@ type MessageSender interface {
 	SetTrace(bool)
 }
 
+// Prober is implemented by a MessageSender which can check that its destination is reachable
+// and accepts its credentials, without sending a LogEvent.
+type Prober interface {
+	Probe() error
+}
+
+// Renderer is implemented by a MessageSender which can show what it would send for a LogEvent.
+// Render must not require OpenSvc and must not connect to the destination.
+type Renderer interface {
+	Render(LogEvent) (Payload, error)
+}
+
 // Attributes contains the properties of a single LogEvent that may be passed as headers to an intermediate system (such as a message queue).
 type Attributes struct {
 	CustomerCode      string `json:"customer_code,omitempty"`
@@ -37,4 +55,21 @@ type MessageContent struct {
 	Time       time.Time              `json:"time,omitempty"`
 	Fields     map[string]interface{} `json:"fields,omitempty"`
 	Event      interface{}            `json:"event,omitempty"`
+	Metric     *Metric                `json:"metric,omitempty"` // when set, Event is ignored
+	Raw        bool                   `json:"raw,omitempty"`    // sendhec: send Event as text to the raw endpoint
+}
+
+// Metric is one or more measurements, such as for a Splunk metrics index, which share a time and dimensions.
+// Values maps each metric name (such as cpu.idle) to its value, and must not be empty.
+type Metric struct {
+	Dimensions map[string]string  `json:"dimensions,omitempty"`
+	Values     map[string]float64 `json:"values"`
+}
+
+// Payload is the data a MessageSender would send for a single LogEvent.
+type Payload struct {
+	Body       []byte            // message body, exactly as sent
+	Headers    map[string]string // protocol headers or message attributes; secrets are masked
+	Properties map[string]string // other message properties, such as AMQP content type or expiration
+	Target     string            // destination, such as a URL, exchange and routing key, or topic ARN; secrets are masked
 }
@@ -0,0 +1,68 @@
+package multiline
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Flags holds command-line settings for multi-line record assembly.
+type Flags struct {
+	Continue string
+	MaxLines int
+	Preset   string
+	Start    string
+	Timeout  time.Duration
+}
+
+// Config returns the Config described by the flags, or nil when multi-line assembly was not requested.
+// Explicit patterns override those of a preset.
+func (f *Flags) Config() (*Config, error) {
+	if f.Preset == "" && f.Start == "" && f.Continue == "" {
+		return nil, nil
+	}
+	cfg := Config{
+		MaxLines: 1000,
+		Timeout:  time.Second,
+	}
+	if f.Preset != "" {
+		var err error
+		cfg, err = Preset(f.Preset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.Start != "" {
+		re, err := regexp.Compile(f.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern: %v", err)
+		}
+		cfg.Start = re
+	}
+	if f.Continue != "" {
+		re, err := regexp.Compile(f.Continue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline continue pattern: %v", err)
+		}
+		cfg.Continue = re
+	}
+	if f.MaxLines > 0 {
+		cfg.MaxLines = f.MaxLines
+	}
+	if f.Timeout > 0 {
+		cfg.Timeout = f.Timeout
+	}
+	return &cfg, nil
+}
+
+// Register adds -multiline, -multiline-start, -multiline-continue,
+// -multiline-max-lines and -multiline-timeout to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Preset, "multiline", "", "multi-line preset: "+strings.Join(PresetNames(), ", "))
+	fs.StringVar(&f.Start, "multiline-start", "", "regexp; lines NOT matching continue the previous record")
+	fs.StringVar(&f.Continue, "multiline-continue", "", "regexp; lines matching continue the previous record")
+	fs.IntVar(&f.MaxLines, "multiline-max-lines", 0, "maximum lines per record (default 1000)")
+	fs.DurationVar(&f.Timeout, "multiline-timeout", 0, "send a pending record after this long without input (default 1s)")
+}
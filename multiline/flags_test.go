@@ -0,0 +1,64 @@
+package multiline
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestFlags(t *testing.T) {
+	t.Run("not requested",
+		func(t *testing.T) {
+			f := Flags{}
+			cfg, err := f.Config()
+			if cfg != nil || err != nil {
+				t.Errorf("expected nil, nil; got %#v, %v", cfg, err)
+			}
+		},
+	)
+
+	t.Run("preset with overrides",
+		func(t *testing.T) {
+			f := Flags{}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f.Register(fs)
+			err := fs.Parse([]string{"-multiline", "java", "-multiline-start", `^\d`, "-multiline-timeout", "5s"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := f.Config()
+			if err != nil {
+				t.Fatalf("Config() returned unexpected error %v", err)
+			}
+			if cfg.Continue == nil || cfg.Start == nil {
+				t.Errorf("expected Continue and Start patterns, got %#v", cfg)
+			}
+			if cfg.Timeout != 5*time.Second {
+				t.Errorf("expected Timeout 5s, got %v", cfg.Timeout)
+			}
+			if cfg.MaxLines != 1000 {
+				t.Errorf("expected MaxLines 1000, got %d", cfg.MaxLines)
+			}
+		},
+	)
+
+	t.Run("invalid pattern",
+		func(t *testing.T) {
+			f := Flags{Continue: "("}
+			_, err := f.Config()
+			if err == nil {
+				t.Error("expected error but got nil")
+			}
+		},
+	)
+
+	t.Run("invalid preset",
+		func(t *testing.T) {
+			f := Flags{Preset: "nope"}
+			_, err := f.Config()
+			if err == nil {
+				t.Error("expected error but got nil")
+			}
+		},
+	)
+}
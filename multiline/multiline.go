@@ -0,0 +1,147 @@
+package multiline
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config contains the rules used to decide whether a line begins a new record
+// or continues the current one.
+//
+// A line continues the current record when any of the following is true:
+//   - it matches Continue;
+//   - Start is set and the line does NOT match Start;
+//   - the previous line matched ContinueNext.
+//
+// Otherwise the current record is complete and the line begins a new record.
+type Config struct {
+	Continue     *regexp.Regexp
+	ContinueNext *regexp.Regexp
+	MaxLines     int // complete a record after this many lines; 0 for no limit
+	Start        *regexp.Regexp
+	Timeout      time.Duration // complete a pending record after no lines arrive for this long; 0 to disable
+}
+
+var presets = map[string]Config{
+	// exception line, then indented "at ..." frames, "... N more" and "Caused by:" chains
+	"java": {
+		Continue: regexp.MustCompile(`^(\s|Caused by:|Suppressed:|\.\.\. \d+ more|[\w.$]+(Exception|Error|Throwable)(: .*)?$)`),
+		MaxLines: 1000,
+		Timeout:  time.Second,
+	},
+	// "Traceback (most recent call last):", indented frames, then the unindented exception line
+	"python": {
+		Continue:     regexp.MustCompile(`^(\s|$|Traceback \(most recent call last\):|During handling of the above exception|The above exception was the direct cause)`),
+		ContinueNext: regexp.MustCompile(`^\s`),
+		MaxLines:     1000,
+		Timeout:      time.Second,
+	},
+	// "panic: ..." or "fatal error: ...", then goroutine headers, function calls and indented file:line frames
+	"go": {
+		Continue: regexp.MustCompile(`^(\s|$|goroutine \d+ \[|\[signal |created by |exit status \d+|[\w./*()%-]+\(.*\)$)`),
+		MaxLines: 1000,
+		Timeout:  time.Second,
+	},
+}
+
+// Preset returns a built-in Config by name: "java", "python" or "go".
+func Preset(name string) (Config, error) {
+	cfg, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return Config{}, errors.New("unknown multiline preset " + name + "; valid presets are " + strings.Join(PresetNames(), ", "))
+	}
+	return cfg, nil
+}
+
+// PresetNames returns the names of all built-in presets.
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Aggregator assembles lines into multi-line records.
+// It is not goroutine-safe; see Writer for a goroutine-safe io.Writer.
+type Aggregator struct {
+	cfg           Config
+	lastAdd       time.Time
+	lines         []string
+	nextContinues bool
+}
+
+// Add adds one line (without its trailing newline) and returns any records completed by it.
+func (a *Aggregator) Add(line string) []string {
+	var records []string
+	if len(a.lines) > 0 && !a.continues(line) {
+		records = append(records, a.take())
+	}
+	a.lines = append(a.lines, line)
+	a.lastAdd = time.Now()
+	a.nextContinues = a.cfg.ContinueNext != nil && a.cfg.ContinueNext.MatchString(line)
+	if a.cfg.MaxLines > 0 && len(a.lines) >= a.cfg.MaxLines {
+		records = append(records, a.take())
+	}
+	return records
+}
+
+// Expired returns true when a pending record has waited longer than the configured timeout.
+func (a *Aggregator) Expired(now time.Time) bool {
+	return len(a.lines) > 0 && a.cfg.Timeout > 0 && now.Sub(a.lastAdd) >= a.cfg.Timeout
+}
+
+// Flush completes and returns the pending record, if any.
+func (a *Aggregator) Flush() (string, bool) {
+	if len(a.lines) == 0 {
+		return "", false
+	}
+	return a.take(), true
+}
+
+// Len returns the number of lines in the pending record.
+func (a *Aggregator) Len() int {
+	return len(a.lines)
+}
+
+// Reset discards the pending record.
+func (a *Aggregator) Reset() {
+	a.lines = nil
+	a.nextContinues = false
+}
+
+// Timeout returns the configured timeout.
+func (a *Aggregator) Timeout() time.Duration {
+	return a.cfg.Timeout
+}
+
+func (a *Aggregator) continues(line string) bool {
+	if a.nextContinues {
+		return true
+	}
+	if a.cfg.Continue != nil && a.cfg.Continue.MatchString(line) {
+		return true
+	}
+	if a.cfg.Start != nil && !a.cfg.Start.MatchString(line) {
+		return true
+	}
+	return false
+}
+
+func (a *Aggregator) take() string {
+	record := strings.Join(a.lines, "\n")
+	a.Reset()
+	return record
+}
+
+// New creates a new Aggregator.
+func New(cfg Config) *Aggregator {
+	a := Aggregator{
+		cfg: cfg,
+	}
+	return &a
+}
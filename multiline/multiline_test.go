@@ -0,0 +1,159 @@
+package multiline
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feed adds all lines, then flushes, returning every record.
+func feed(a *Aggregator, lines []string) []string {
+	var records []string
+	for _, line := range lines {
+		records = append(records, a.Add(line)...)
+	}
+	if record, ok := a.Flush(); ok {
+		records = append(records, record)
+	}
+	return records
+}
+
+func expectRecords(t *testing.T, got []string, expect ...string) {
+	t.Helper()
+	if len(got) != len(expect) {
+		t.Fatalf("expected %d records %#v, got %d %#v", len(expect), expect, len(got), got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Errorf("record %d: expected %#v got %#v", i, expect[i], got[i])
+		}
+	}
+}
+
+func TestAggregator_rules(t *testing.T) {
+	t.Run("start pattern",
+		func(t *testing.T) {
+			a := New(Config{Start: regexp.MustCompile(`^\d{4}-`)})
+			records := feed(a, []string{"2020-01-01 one", "  more", "2020-01-02 two", "2020-01-03 three", "x"})
+			expectRecords(t, records, "2020-01-01 one\n  more", "2020-01-02 two", "2020-01-03 three\nx")
+		},
+	)
+
+	t.Run("continuation pattern",
+		func(t *testing.T) {
+			a := New(Config{Continue: regexp.MustCompile(`^\s`)})
+			records := feed(a, []string{"one", " a", " b", "two"})
+			expectRecords(t, records, "one\n a\n b", "two")
+		},
+	)
+
+	t.Run("no rules",
+		func(t *testing.T) {
+			a := New(Config{})
+			records := feed(a, []string{"one", " two"})
+			expectRecords(t, records, "one", " two")
+		},
+	)
+
+	t.Run("max lines",
+		func(t *testing.T) {
+			a := New(Config{Continue: regexp.MustCompile(`.`), MaxLines: 2})
+			records := feed(a, []string{"a", "b", "c"})
+			expectRecords(t, records, "a\nb", "c")
+		},
+	)
+}
+
+func TestAggregator_Expired(t *testing.T) {
+	a := New(Config{Timeout: time.Second})
+	if a.Expired(time.Now().Add(time.Hour)) {
+		t.Error("expected Expired() false with nothing pending")
+	}
+	a.Add("one")
+	if a.Expired(time.Now()) {
+		t.Error("expected Expired() false immediately after Add()")
+	}
+	if !a.Expired(time.Now().Add(2 * time.Second)) {
+		t.Error("expected Expired() true after timeout")
+	}
+	a.Reset()
+	if a.Len() != 0 {
+		t.Errorf("expected Len() 0 after Reset(), got %d", a.Len())
+	}
+}
+
+func TestPreset(t *testing.T) {
+	_, err := Preset("cobol")
+	if err == nil {
+		t.Error("expected error for unknown preset but got nil")
+	}
+	if strings.Join(PresetNames(), ",") != "go,java,python" {
+		t.Errorf("unexpected PresetNames(), got %v", PresetNames())
+	}
+
+	tests := []struct {
+		preset string
+		lines  []string
+		expect []string
+	}{
+		{
+			"java",
+			[]string{
+				"2020-01-01 ERROR request failed",
+				"java.lang.IllegalStateException: boom",
+				"\tat com.example.Foo.bar(Foo.java:10)",
+				"\tat com.example.Main.main(Main.java:5)",
+				"Caused by: java.io.IOException: disk",
+				"\t... 2 more",
+				"2020-01-01 INFO next",
+			},
+			[]string{
+				"2020-01-01 ERROR request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Foo.bar(Foo.java:10)\n\tat com.example.Main.main(Main.java:5)\nCaused by: java.io.IOException: disk\n\t... 2 more",
+				"2020-01-01 INFO next",
+			},
+		},
+		{
+			"python",
+			[]string{
+				"Traceback (most recent call last):",
+				`  File "x.py", line 1, in <module>`,
+				"    foo()",
+				"ValueError: bad",
+				"next line",
+			},
+			[]string{
+				"Traceback (most recent call last):\n  File \"x.py\", line 1, in <module>\n    foo()\nValueError: bad",
+				"next line",
+			},
+		},
+		{
+			"go",
+			[]string{
+				"panic: runtime error: index out of range [5] with length 3",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/tmp/x.go:8 +0x1d",
+				"exit status 2",
+				"next line",
+			},
+			[]string{
+				"panic: runtime error: index out of range [5] with length 3\n\ngoroutine 1 [running]:\nmain.main()\n\t/tmp/x.go:8 +0x1d\nexit status 2",
+				"next line",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset,
+			func(t *testing.T) {
+				cfg, err := Preset(tt.preset)
+				if err != nil {
+					t.Fatalf("Preset() returned unexpected error %v", err)
+				}
+				records := feed(New(cfg), tt.lines)
+				expectRecords(t, records, tt.expect...)
+			},
+		)
+	}
+}
@@ -0,0 +1,113 @@
+package multiline
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer is an io.WriteCloser which splits written data into lines, assembles
+// them into records and passes each completed record to an emit function.
+// It is goroutine-safe; a pending record is emitted after the Config Timeout
+// even if nothing more is written.
+type Writer struct {
+	agg     *Aggregator
+	closed  bool
+	emit    func(string) error
+	mtx     sync.Mutex
+	partial []byte
+	timer   *time.Timer
+}
+
+// Close emits any partial line and pending record.
+func (w *Writer) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	var firstErr error
+	if len(w.partial) > 0 {
+		firstErr = w.emitAll(w.agg.Add(w.line(w.partial)))
+		w.partial = nil
+	}
+	if record, ok := w.agg.Flush(); ok {
+		err := w.emit(record)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Write adds data; every complete line is passed to the Aggregator.
+// The first error returned by emit is returned, but all records are attempted.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	data := append(w.partial, p...)
+	var firstErr error
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		err := w.emitAll(w.agg.Add(w.line(data[:i])))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		data = data[i+1:]
+	}
+	w.partial = append([]byte(nil), data...)
+	if w.agg.Timeout() > 0 && w.agg.Len() > 0 {
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.agg.Timeout(), w.flushExpired)
+		} else {
+			w.timer.Reset(w.agg.Timeout())
+		}
+	}
+	return len(p), firstErr
+}
+
+func (w *Writer) emitAll(records []string) error {
+	var firstErr error
+	for _, record := range records {
+		err := w.emit(record)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (w *Writer) flushExpired() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed || !w.agg.Expired(time.Now()) {
+		return
+	}
+	record, _ := w.agg.Flush()
+	err := w.emit(record)
+	if err != nil {
+		log.Println("multiline: unable to emit record after timeout:", err)
+	}
+}
+
+func (w *Writer) line(b []byte) string {
+	return strings.TrimRight(string(b), "\r")
+}
+
+// NewWriter creates a new Writer which passes each completed record to emit.
+func NewWriter(cfg Config, emit func(string) error) *Writer {
+	w := Writer{
+		agg:  New(cfg),
+		emit: emit,
+	}
+	return &w
+}
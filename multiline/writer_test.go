@@ -0,0 +1,70 @@
+package multiline
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	err     error
+	mtx     sync.Mutex
+	records []string
+}
+
+func (r *recorder) emit(record string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.records = append(r.records, record)
+	return r.err
+}
+
+func (r *recorder) get() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string{}, r.records...)
+}
+
+func TestWriter(t *testing.T) {
+	r := &recorder{}
+	w := NewWriter(Config{Continue: regexp.MustCompile(`^\s`)}, r.emit)
+	w.Write([]byte("one\r\n  a\n"))
+	w.Write([]byte("  b\ntw"))
+	expectRecords(t, r.get())
+	w.Write([]byte("o\nthree"))
+	expectRecords(t, r.get(), "one\n  a\n  b")
+	err := w.Close()
+	if err != nil {
+		t.Errorf("Close() returned unexpected error %v", err)
+	}
+	expectRecords(t, r.get(), "one\n  a\n  b", "two", "three")
+	w.Close()
+	expectRecords(t, r.get(), "one\n  a\n  b", "two", "three")
+}
+
+func TestWriter_timeout(t *testing.T) {
+	r := &recorder{}
+	w := NewWriter(Config{Continue: regexp.MustCompile(`^\s`), Timeout: 20 * time.Millisecond}, r.emit)
+	defer w.Close()
+	w.Write([]byte("one\n  a\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	expectRecords(t, r.get(), "one\n  a")
+}
+
+func TestWriter_emitError(t *testing.T) {
+	r := &recorder{err: errors.New("down")}
+	w := NewWriter(Config{}, r.emit)
+	n, err := w.Write([]byte("one\ntwo\n"))
+	if err == nil {
+		t.Error("expected error from Write() but got nil")
+	}
+	if n != 8 {
+		t.Errorf("expected n=8, got %d", n)
+	}
+	expectRecords(t, r.get(), "one")
+}